@@ -5,32 +5,32 @@ import (
 )
 
 var (
-	InvalidUnmarshalError = errors.New("v must be a non-nil struct pointer")
+	InvalidUnmarshalError = errors.New("v must be a non-nil pointer")
 	InvalidMarshalError   = errors.New("v must be of type map, map pointer, struct or struct pointer")
 	InvalidPropBytes      = errors.New("bytes are not from valid .properties config")
 	UnsupportedTypeError  = errors.New("unsupported type")
 )
 
-func Marshal(v interface{}) ([]byte, error) {
-	return marshal(v)
+func Marshal(v interface{}, opts ...Option) ([]byte, error) {
+	return marshal(v, opts...)
 }
 
-func Unmarshal(data []byte, v interface{}) error {
+func Unmarshal(data []byte, v interface{}, opts ...Option) error {
 	p, err := propsFromBytes(data, "")
 	if err != nil {
 		return err
 	}
-	return UnmarshalKV(p.kv, v)
+	return UnmarshalKV(p.kv, v, opts...)
 }
 
-func UnmarshalKV(kv map[string]string, v interface{}) error {
-	return unmarshalKV(kv, v)
+func UnmarshalKV(kv map[string]string, v interface{}, opts ...Option) error {
+	return unmarshalKV(kv, v, opts...)
 }
 
-func UnmarshalKey(key string, data []byte, v interface{}) error {
-	p, err := propsFromBytes(data, key+".")
+func UnmarshalKey(key string, data []byte, v interface{}, opts ...Option) error {
+	p, err := propsFromBytes(data, "")
 	if err != nil {
 		return err
 	}
-	return UnmarshalKV(p.kv, v)
+	return p.unmarshalKey(key, v, opts...)
 }
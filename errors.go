@@ -0,0 +1,132 @@
+package properties
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A ParseError describes malformed .properties input. It matches
+// InvalidPropBytes with errors.Is.
+type ParseError struct {
+	Filename string // name of the input, if known
+	Line     int    // 1-based line number
+	Column   int    // 1-based column, counted in runes
+	Text     string // the offending line
+	Msg      string
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("properties: %s: %s", e.position(), e.Msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) position() string {
+	if e.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", e.Filename, e.Line, e.Column)
+	}
+	return fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+}
+
+// Pretty formats the error together with the offending line and a caret
+// pointing at the column, e.g.
+//
+//	app.properties:3:6: malformed \uXXXX escape
+//	    3 | name=\u12g4
+//	      |      ^
+func (e *ParseError) Pretty() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s\n", e.position(), e.Msg)
+
+	gutter := fmt.Sprintf("%5d | ", e.Line)
+	sb.WriteString(gutter)
+	sb.WriteString(e.Text)
+	sb.WriteByte('\n')
+
+	sb.WriteString(strings.Repeat(" ", len(gutter)-2))
+	sb.WriteString("| ")
+	// keep tabs so that the caret lines up with the text above
+	for i, r := range []rune(e.Text) {
+		if i >= e.Column-1 {
+			break
+		}
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	sb.WriteString("^\n")
+	return sb.String()
+}
+
+// An UnmarshalTypeError describes a value that could not be decoded into a
+// Go value of a specific type.
+type UnmarshalTypeError struct {
+	Key   string       // full key path, e.g. "servers[2].port"
+	Value string       // the raw value
+	Field string       // Go field path, e.g. "Servers[2].Port"
+	Type  reflect.Type // type of the Go value it could not be assigned to
+	Err   error
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	msg := fmt.Sprintf("properties: cannot unmarshal key %q into", e.Key)
+	if e.Value != "" {
+		msg = fmt.Sprintf("properties: cannot unmarshal %q at key %q into", e.Value, e.Key)
+	}
+	if e.Field != "" {
+		msg += " Go field " + e.Field + " of"
+	}
+	msg += " type " + e.Type.String()
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *UnmarshalTypeError) Unwrap() error {
+	return e.Err
+}
+
+// A MultiError lists every failure of a decoding with AllErrors, in the order
+// they were found. The failures can be inspected with errors.Is and
+// errors.As.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// A RequiredKeyError lists the absent keys of fields tagged as required.
+type RequiredKeyError struct {
+	Keys []string // full key paths, e.g. "servers[2].host"
+}
+
+func (e *RequiredKeyError) Error() string {
+	return "properties: missing required keys: " + strings.Join(e.Keys, ", ")
+}
+
+// An UnknownKeyError lists the keys of the input that no field consumed, as
+// reported with DisallowUnknownKeys.
+type UnknownKeyError struct {
+	Keys []string
+}
+
+func (e *UnknownKeyError) Error() string {
+	return "properties: unknown keys: " + strings.Join(e.Keys, ", ")
+}
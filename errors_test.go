@@ -0,0 +1,59 @@
+package properties
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	t.Run("position", func(t *testing.T) {
+		input := []byte("a=1\n" +
+			"# comment\n" +
+			"\tname=\\u12g4\n")
+
+		_, err := propsFromBytes(input, "")
+		var pe *ParseError
+		assert.True(t, errors.As(err, &pe))
+		assert.True(t, errors.Is(err, InvalidPropBytes))
+		assert.Equal(t, 3, pe.Line)
+		assert.Equal(t, 7, pe.Column)
+		assert.Equal(t, "\tname=\\u12g4", pe.Text)
+		assert.Equal(t, `properties: line 3, column 7: malformed \uXXXX escape`, pe.Error())
+		assert.Equal(t, "line 3, column 7: malformed \\uXXXX escape\n"+
+			"    3 | \tname=\\u12g4\n"+
+			"      | \t     ^\n", pe.Pretty())
+	})
+
+	t.Run("position in continuation line", func(t *testing.T) {
+		input := []byte("msg=中文, \\\n" +
+			"    more \\u00zz\n")
+
+		_, err := propsFromBytes(input, "")
+		var pe *ParseError
+		assert.True(t, errors.As(err, &pe))
+		assert.Equal(t, 2, pe.Line)
+		assert.Equal(t, 10, pe.Column)
+		assert.Equal(t, "    more \\u00zz", pe.Text)
+	})
+
+	t.Run("file name", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "app.properties")
+		assert.NoError(t, os.WriteFile(name, []byte("a\\u=1\n"), 0644))
+
+		f, err := os.Open(name)
+		assert.NoError(t, err)
+		defer f.Close()
+
+		var given struct {
+			A string `properties:"a"`
+		}
+		err = NewDecoder(f).Decode(&given)
+		var pe *ParseError
+		assert.True(t, errors.As(err, &pe))
+		assert.Equal(t, name, pe.Filename)
+		assert.Equal(t, "properties: "+name+":1:2: malformed \\uXXXX escape", err.Error())
+	})
+}
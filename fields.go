@@ -0,0 +1,181 @@
+package properties
+
+import (
+	"reflect"
+	"sort"
+)
+
+// A structField is a field of a struct, or of a struct flattened into it,
+// decoded and encoded at key below the key of the struct.
+type structField struct {
+	key   string
+	name  string // Go field path from the struct, e.g. "BaseServer.Host"
+	index []int
+	typ   reflect.Type
+	opts  tagOptions
+	// rest marks an inline field that is not flattened, such as a map,
+	// which holds the keys that no other field takes.
+	rest   bool
+	tagged bool
+	depth  int
+}
+
+// structFields returns the fields of the struct type t, with the fields of
+// its inline structs flattened, in order of declaration. As in
+// encoding/json, of the fields sharing a key the shallowest one wins, then
+// the only tagged one among the shallowest; the others are dropped.
+func (o *options) structFields(t reflect.Type) []structField {
+	type embed struct {
+		typ   reflect.Type
+		index []int
+		name  string
+	}
+
+	var fields []structField
+	visited := map[reflect.Type]bool{}
+	next := []embed{{typ: t}}
+	for depth := 0; len(next) > 0; depth++ {
+		current := next
+		next = nil
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i := 0; i < e.typ.NumField(); i++ {
+				tf := e.typ.Field(i)
+				name, opts := parseTag(tf.Tag.Get(tagName))
+				if name == "-" {
+					continue
+				}
+
+				index := append(append([]int(nil), e.index...), i)
+				path := tf.Name
+				if e.name != "" {
+					path = e.name + "." + tf.Name
+				}
+
+				if isInline(tf, name, opts) && isFlattened(tf.Type) {
+					// NOTE: the exported fields of an unexported embedded
+					// struct are still settable, unlike through a pointer
+					if tf.IsExported() || tf.Type.Kind() == reflect.Struct {
+						next = append(next, embed{indirect(tf.Type), index, path})
+					}
+					continue
+				}
+				if !tf.IsExported() {
+					continue
+				}
+
+				f := structField{name: path, index: index, typ: tf.Type, opts: opts, depth: depth}
+				if isInline(tf, name, opts) {
+					f.rest = true
+				} else {
+					f.key = o.fieldKey(tf.Name, name)
+					f.tagged = name != ""
+				}
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	return dominantFields(fields)
+}
+
+// isFlattened reports whether the fields of an inline field of type t take
+// its place, rather than t decoding and encoding itself.
+func isFlattened(t reflect.Type) bool {
+	pt := reflect.PtrTo(indirect(t))
+	return isNested(t) && !pt.Implements(unmarshalerType) && !pt.Implements(marshalerType)
+}
+
+// dominantFields keeps, for every key, the field that wins over the others,
+// if any, and sorts the fields in order of declaration.
+func dominantFields(fields []structField) []structField {
+	byKey := map[string][]int{}
+	for i, f := range fields {
+		if !f.rest {
+			byKey[f.key] = append(byKey[f.key], i)
+		}
+	}
+
+	var kept []structField
+	for i, f := range fields {
+		if f.rest || dominates(fields, byKey[f.key], i) {
+			kept = append(kept, f)
+		}
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		a, b := kept[i].index, kept[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return kept
+}
+
+// dominates reports whether fields[i] wins over the fields at same, which
+// share its key.
+func dominates(fields []structField, same []int, i int) bool {
+	depth := fields[i].depth
+	for _, j := range same {
+		if fields[j].depth < depth {
+			return false
+		}
+	}
+
+	var shallowest, tagged []int
+	for _, j := range same {
+		if fields[j].depth == depth {
+			shallowest = append(shallowest, j)
+			if fields[j].tagged {
+				tagged = append(tagged, j)
+			}
+		}
+	}
+	if len(shallowest) == 1 {
+		return true
+	}
+	return len(tagged) == 1 && tagged[0] == i
+}
+
+// fieldByIndex returns the field of the struct v at index. Nil pointers to
+// embedded structs on the way are allocated when alloc is set, and
+// otherwise make it report false.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// claims reports whether the key k, relative to the struct, is taken by one
+// of the fields that are not rest fields.
+func (p *props) claims(fields []structField, k string) bool {
+	for _, f := range fields {
+		if f.rest {
+			continue
+		}
+		if _, ok := p.cutPrefix(k+".", f.key+"."); ok {
+			return true
+		}
+		if _, ok := p.cutPrefix(k, f.key+"["); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package properties
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A DecodeHook converts the raw value s into a value assignable to type t,
+// or to a pointer to t. It reports ok=false to leave t to the next hook and
+// eventually to the built-in decoding.
+type DecodeHook func(t reflect.Type, s string) (v interface{}, ok bool, err error)
+
+// An EncodeHook formats v as a raw value. It reports ok=false to leave v to
+// the next hook and eventually to the built-in encoding.
+type EncodeHook func(v reflect.Value) (s string, ok bool, err error)
+
+// AddDecodeHook appends h to the hooks consulted, in order, before decoding
+// any value.
+func (dec *Decoder) AddDecodeHook(h DecodeHook) {
+	dec.opts = append(dec.opts, func(o *options) {
+		o.decodeHooks = append(o.decodeHooks, h)
+	})
+}
+
+// RegisterDecoder makes the decoder decode values of type t with fn, e.g.
+//
+//	dec.RegisterDecoder(reflect.TypeOf(&url.URL{}), func(s string) (interface{}, error) {
+//		return url.Parse(s)
+//	})
+func (dec *Decoder) RegisterDecoder(t reflect.Type, fn func(s string) (interface{}, error)) {
+	dec.AddDecodeHook(func(tt reflect.Type, s string) (interface{}, bool, error) {
+		if tt != t {
+			return nil, false, nil
+		}
+		v, err := fn(s)
+		return v, true, err
+	})
+}
+
+// AddEncodeHook appends h to the hooks consulted, in order, before encoding
+// any value.
+func (enc *Encoder) AddEncodeHook(h EncodeHook) {
+	enc.opts = append(enc.opts, func(o *options) {
+		o.encodeHooks = append(o.encodeHooks, h)
+	})
+}
+
+// RegisterEncoder makes the encoder encode values of type t with fn. Nil
+// pointers are skipped without calling fn.
+func (enc *Encoder) RegisterEncoder(t reflect.Type, fn func(v interface{}) (string, error)) {
+	enc.AddEncodeHook(func(v reflect.Value) (string, bool, error) {
+		if v.Type() != t {
+			return "", false, nil
+		}
+		s, err := fn(v.Interface())
+		return s, true, err
+	})
+}
+
+// hook decodes key into v with the first decode hook that handles the type
+// of v. Hooks are only consulted for keys that are present, and for values
+// that can be set.
+func (p *props) hook(key string, v reflect.Value) (bool, error) {
+	hooks := p.d.opts.decodeHooks
+	if len(hooks) == 0 || !v.CanSet() {
+		return false, nil
+	}
+	key, ok := p.lookup(key)
+	if !ok {
+		return false, nil
+	}
+	s := p.kv[key]
+
+	for _, h := range hooks {
+		x, handled, err := h(v.Type(), s)
+		if !handled && err == nil {
+			continue
+		}
+		p.use(key)
+		if err != nil {
+			return true, p.typeError(key, s, v.Type(), err)
+		}
+		if err := assign(v, x); err != nil {
+			return true, p.typeError(key, s, v.Type(), err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// assign stores x, or what x points to, into v.
+func assign(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	xv := reflect.ValueOf(x)
+	switch {
+	case xv.Type().AssignableTo(v.Type()):
+		v.Set(xv)
+	case xv.Kind() == reflect.Ptr && xv.Type().Elem().AssignableTo(v.Type()):
+		if xv.IsNil() {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(xv.Elem())
+		}
+	default:
+		return fmt.Errorf("decode hook returned %s", xv.Type())
+	}
+	return nil
+}
+
+// hook encodes v with the first encode hook that handles its type.
+func (e *encodeState) hook(v reflect.Value) (string, bool, error) {
+	if !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return "", false, nil
+	}
+
+	for _, h := range e.opts.encodeHooks {
+		if s, ok, err := h(v); ok || err != nil {
+			return s, true, err
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,173 @@
+package properties
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecoder__hooks(t *testing.T) {
+	type S struct {
+		Endpoint *url.URL       `properties:"endpoint"`
+		Pattern  *regexp.Regexp `properties:"pattern"`
+		Supply   big.Int        `properties:"supply"`
+		Mirrors  []*url.URL     `properties:"mirrors"`
+		Limits   map[string]int `properties:"limits"`
+		Name     string         `properties:"name"`
+	}
+
+	input := `
+		endpoint=https://api.example.com/v1
+		pattern=^[a-z]+$
+		supply=123456789012345678901234567890
+		mirrors[0]=https://a.example
+		mirrors[1]=https://b.example
+		limits.a=1k
+		name=app
+	`
+
+	newDecoder := func(input string) *Decoder {
+		dec := NewDecoder(strings.NewReader(input))
+		dec.RegisterDecoder(reflect.TypeOf(&url.URL{}), func(s string) (interface{}, error) {
+			return url.Parse(s)
+		})
+		dec.RegisterDecoder(reflect.TypeOf(&regexp.Regexp{}), func(s string) (interface{}, error) {
+			return regexp.Compile(s)
+		})
+		dec.RegisterDecoder(reflect.TypeOf(big.Int{}), func(s string) (interface{}, error) {
+			n, ok := new(big.Int).SetString(s, 10)
+			if !ok {
+				return nil, errors.New("invalid integer")
+			}
+			return n, nil
+		})
+		dec.AddDecodeHook(func(t reflect.Type, s string) (interface{}, bool, error) {
+			if t.Kind() != reflect.Int || !strings.HasSuffix(s, "k") {
+				return nil, false, nil
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(s, "k"))
+			return n * 1000, true, err
+		})
+		return dec
+	}
+
+	var given S
+	assert.NoError(t, newDecoder(input).Decode(&given))
+	assert.Equal(t, "api.example.com", given.Endpoint.Host)
+	assert.True(t, given.Pattern.MatchString("abc"))
+	assert.Equal(t, "123456789012345678901234567890", given.Supply.String())
+	assert.Equal(t, "b.example", given.Mirrors[1].Host)
+	assert.Equal(t, map[string]int{"a": 1000}, given.Limits)
+	assert.Equal(t, "app", given.Name)
+
+	t.Run("hook error", func(t *testing.T) {
+		var given S
+		err := newDecoder("pattern=[a-\nsupply=x").Decode(&given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "pattern", te.Key)
+		assert.Equal(t, reflect.TypeOf(&regexp.Regexp{}), te.Type)
+	})
+
+	t.Run("wrong type returned", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader("name=app"))
+		dec.RegisterDecoder(reflect.TypeOf(""), func(s string) (interface{}, error) {
+			return 1, nil
+		})
+		var given S
+		err := dec.Decode(&given)
+		assert.EqualError(t, err, `properties: cannot unmarshal "app" at key "name" into Go field Name of type string: `+
+			`decode hook returned int`)
+	})
+}
+
+func TestEncoder__hooks(t *testing.T) {
+	type S struct {
+		Endpoint *url.URL       `properties:"endpoint"`
+		Pattern  *regexp.Regexp `properties:"pattern"`
+		Missing  *regexp.Regexp `properties:"missing"`
+		Supply   *big.Int       `properties:"supply"`
+		Name     string         `properties:"name"`
+	}
+
+	endpoint, _ := url.Parse("https://api.example.com/v1")
+	supply, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	s := S{
+		Endpoint: endpoint,
+		Pattern:  regexp.MustCompile("^[a-z]+$"),
+		Supply:   supply,
+		Name:     "app",
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.RegisterEncoder(reflect.TypeOf(&url.URL{}), func(v interface{}) (string, error) {
+		return v.(*url.URL).String(), nil
+	})
+	enc.AddEncodeHook(func(v reflect.Value) (string, bool, error) {
+		if s, ok := v.Interface().(interface{ String() string }); ok && v.Kind() == reflect.Ptr {
+			return s.String(), true, nil
+		}
+		return "", false, nil
+	})
+	assert.NoError(t, enc.Encode(s))
+	assert.Equal(t, "endpoint=https://api.example.com/v1\n"+
+		"pattern=^[a-z]+$\n"+
+		"supply=123456789012345678901234567890\n"+
+		"name=app\n", buf.String())
+}
+
+type upstreams struct {
+	urls map[string]url.URL
+}
+
+func (u *upstreams) UnmarshalProperties(sub *Properties) error {
+	return sub.Unmarshal(&u.urls)
+}
+
+func TestDecoder__hooks_on_map_values(t *testing.T) {
+	parseURL := func(s string) (interface{}, error) {
+		return url.Parse(s)
+	}
+
+	input := "m.a=http://a\nm.b=http://b\nup.primary=http://p\n"
+
+	t.Run("map of pointers", func(t *testing.T) {
+		var given struct {
+			M map[string]*url.URL `properties:"m"`
+		}
+		dec := NewDecoder(strings.NewReader(input))
+		dec.RegisterDecoder(reflect.TypeOf(&url.URL{}), parseURL)
+		assert.NoError(t, dec.Decode(&given))
+		assert.Equal(t, "b", given.M["b"].Host)
+		assert.Len(t, given.M, 2)
+	})
+
+	t.Run("map of values", func(t *testing.T) {
+		var given struct {
+			M map[string]url.URL `properties:"m"`
+		}
+		dec := NewDecoder(strings.NewReader(input))
+		dec.RegisterDecoder(reflect.TypeOf(url.URL{}), parseURL)
+		assert.NoError(t, dec.Decode(&given))
+		assert.Equal(t, "a", given.M["a"].Host)
+		assert.Len(t, given.M, 2)
+	})
+
+	t.Run("properties unmarshal", func(t *testing.T) {
+		var given struct {
+			Up upstreams `properties:"up"`
+		}
+		dec := NewDecoder(strings.NewReader(input))
+		dec.RegisterDecoder(reflect.TypeOf(url.URL{}), parseURL)
+		assert.NoError(t, dec.Decode(&given))
+		assert.Equal(t, "p", given.Up.urls["primary"].Host)
+	})
+}
@@ -0,0 +1,76 @@
+package properties
+
+import (
+	"strings"
+)
+
+// Key segments holding '.', '[', ']' or '"', such as the map key
+// "api.example.com", are written in double quotes, with any quote inside
+// doubled:
+//
+//	upstream."api.example.com".weight=3
+//	labels."say ""hi""".text=hello
+
+// quotedLen returns the length of the quoted segment s starts with, quotes
+// included, or -1 when s does not start with one.
+func quotedLen(s string) int {
+	if len(s) == 0 || s[0] != '"' {
+		return -1
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] != '"' {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return -1
+}
+
+// firstSegment returns the first segment of the key k, up to the first '.'
+// or '[' outside of quotes.
+func firstSegment(k string) string {
+	i := 0
+	if n := quotedLen(k); n > 0 {
+		i = n
+	}
+	if j := strings.IndexAny(k[i:], ".["); j != -1 {
+		return k[:i+j]
+	}
+	return k
+}
+
+// unquoteSegment returns the segment s without its quotes, or s itself when
+// it is not quoted.
+func unquoteSegment(s string) string {
+	if quotedLen(s) != len(s) {
+		return s
+	}
+	return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+}
+
+// unquoteKey returns the key k with each of its segments unquoted, e.g.
+// api.example.com.weight for "api.example.com".weight.
+func unquoteKey(k string) string {
+	var sb strings.Builder
+	for {
+		seg := firstSegment(k)
+		sb.WriteString(unquoteSegment(seg))
+		if len(seg) == len(k) {
+			return sb.String()
+		}
+		sb.WriteByte(k[len(seg)])
+		k = k[len(seg)+1:]
+	}
+}
+
+// quoteSegment quotes s when it would not read back as a single segment.
+func quoteSegment(s string) string {
+	if s != "" && !strings.ContainsAny(s, `.[]"`) {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
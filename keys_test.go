@@ -0,0 +1,24 @@
+package properties
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKeySegments(t *testing.T) {
+	assert.Equal(t, "a", firstSegment("a.b[0]"))
+	assert.Equal(t, "a", firstSegment("a[0].b"))
+	assert.Equal(t, `"a.b[0]"`, firstSegment(`"a.b[0]".c`))
+	assert.Equal(t, `"x""y"`, firstSegment(`"x""y"[1]`))
+	assert.Equal(t, `"a`, firstSegment(`"a.b`))
+
+	for _, s := range []string{"plain", "api.example.com", "a[0]", `say "hi"`, ""} {
+		assert.Equal(t, s, unquoteSegment(quoteSegment(s)))
+	}
+	assert.Equal(t, "plain", quoteSegment("plain"))
+	assert.Equal(t, `"say ""hi"""`, quoteSegment(`say "hi"`))
+	assert.Equal(t, `"a`, unquoteSegment(`"a`))
+
+	assert.Equal(t, "api.example.com.weight", unquoteKey(`"api.example.com".weight`))
+	assert.Equal(t, `a.b[0].say "hi"`, unquoteKey(`a."b"[0]."say ""hi"""`))
+}
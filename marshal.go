@@ -1,104 +1,368 @@
 package properties
 
 import (
+	"encoding"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 func toPropLineBytes(key, val string) []byte {
-	return []byte(fmt.Sprintf("%s=%s\n", key, val))
+	return []byte(fmt.Sprintf("%s=%s\n", escapeKey(key), escapeValue(val)))
 }
 
-func marshal(v interface{}) ([]byte, error) {
+// escapeKey escapes s so that it reads back as a single key: separators,
+// whitespace and comment markers are prefixed with a backslash.
+func escapeKey(s string) string {
+	return escape(s, true)
+}
+
+// escapeValue escapes s so that it reads back verbatim as a value, keeping
+// its leading whitespace and staying on a single line.
+func escapeValue(s string) string {
+	return escape(s, false)
+}
+
+func escape(s string, isKey bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		// NOTE: a byte of invalid UTF-8 is kept as is rather than replaced by
+		// U+FFFD, so that it reads back the same
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				sb.WriteByte(s[i])
+				continue
+			}
+		}
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case ' ':
+			if isKey || i == 0 {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(' ')
+		case '=', ':', '#', '!':
+			if isKey {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04x`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func marshal(v interface{}, opts ...Option) ([]byte, error) {
 	rv := reflect.ValueOf(v)
 
 	if rv.Kind() == reflect.Map || rv.Kind() == reflect.Struct ||
 		rv.Kind() == reflect.Ptr && (rv.Elem().Kind() == reflect.Struct || rv.Elem().Kind() == reflect.Map) {
-		return devalue("", rv)
+		e := &encodeState{opts: newOptions(opts)}
+		return e.devalue("", rv, "")
 	}
 
 	return nil, InvalidMarshalError
 }
 
-func devalue(key string, v reflect.Value) ([]byte, error) {
-	var data []byte
-	switch v.Kind() {
-	case reflect.Ptr:
-		return devalue(key, v.Elem())
-	case reflect.Struct:
-		for i := 0; i < v.NumField(); i++ {
-			vf, tf := v.Field(i), v.Type().Field(i)
+type encodeState struct {
+	opts options
+}
 
-			kk, _ := parseTag(tf.Tag.Get(tagName))
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
 
-			if kk == "-" {
-				continue
+// textMarshaler returns v as an encoding.TextMarshaler when v or, for an
+// addressable v, its pointer implements it. Pointers are left to be
+// dereferenced first.
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	if !v.IsValid() || v.Kind() == reflect.Ptr {
+		return nil, false
+	}
+	if v.Type().Implements(textMarshalerType) {
+		return v.Interface().(encoding.TextMarshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textMarshalerType) {
+		return v.Addr().Interface().(encoding.TextMarshaler), true
+	}
+	return nil, false
+}
+
+// mapKeyString formats the map key k as a key segment, the way mapKey parses
+// it back.
+func mapKeyString(k reflect.Value) (string, error) {
+	if k.Type() == durationType {
+		return time.Duration(k.Int()).String(), nil
+	}
+
+	if m, ok := textMarshaler(k); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	if s, ok := formatBasicType(k); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("properties: map key type %s: %w", k.Type(), UnsupportedTypeError)
+}
+
+type mapKey struct {
+	v reflect.Value
+	s string
+}
+
+// sortedMapKeys returns the keys of the map v with their key segments, in
+// numeric order for number kinds and in lexical order of the segments
+// otherwise, so that the output is deterministic.
+func sortedMapKeys(v reflect.Value) ([]mapKey, error) {
+	keys := make([]mapKey, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		s, err := mapKeyString(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, mapKey{k, s})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].v, keys[j].v
+		if _, ok := textMarshaler(a); !ok {
+			switch a.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return a.Int() < b.Int()
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return a.Uint() < b.Uint()
+			case reflect.Float32, reflect.Float64:
+				return a.Float() < b.Float()
 			}
+		}
+		return keys[i].s < keys[j].s
+	})
+	return keys, nil
+}
+
+func (e *encodeState) devalue(key string, v reflect.Value, opts tagOptions) ([]byte, error) {
+	if s, ok, err := e.hook(v); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return toPropLineBytes(key, s), nil
+	}
 
+	if m, ok := propertiesMarshaler(v); ok {
+		kv, err := m.MarshalProperties()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(kv))
+		for k := range kv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var data []byte
+		for _, k := range keys {
+			kk := k
 			if key != "" {
-				kk = fmt.Sprintf("%s.%s", key, kk)
+				kk = fmt.Sprintf("%s.%s", key, k)
 			}
+			data = append(data, toPropLineBytes(kk, kv[k])...)
+		}
+		return data, nil
+	}
 
-			d, err := devalue(kk, vf)
-			if err != nil {
-				return nil, err
-			}
-			data = append(data, d...)
+	if s, ok, err := e.scalar(v, opts); ok || err != nil {
+		if err != nil {
+			return nil, err
 		}
+		return toPropLineBytes(key, s), nil
+	}
+
+	var data []byte
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return e.devalue(key, v.Elem(), opts)
+	case reflect.Struct:
+		return e.devalueStruct(key, v)
 	case reflect.Map:
-		for _, kk := range v.MapKeys() {
-			vv := v.MapIndex(kk)
+		keys, err := sortedMapKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, kk := range keys {
+			vv := v.MapIndex(kk.v)
 
-			var nkey string
+			nkey := quoteSegment(kk.s)
 			if key != "" {
-				nkey = fmt.Sprintf("%s.%s", key, kk.Interface())
-			} else {
-				nkey = fmt.Sprint(kk.Interface())
+				nkey = fmt.Sprintf("%s.%s", key, nkey)
 			}
 
-			d, err := devalue(nkey, vv)
+			d, err := e.devalue(nkey, vv, opts)
 			if err != nil {
 				return nil, err
 			}
 			data = append(data, d...)
 		}
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
+		if sep, ok := opts.splitSep(); ok {
+			return e.join(key, v, sep, opts)
+		}
 		for i := 0; i < v.Len(); i++ {
 			vv := v.Index(i)
-			d, err := devalue(fmt.Sprintf("%s[%d]", key, i), vv)
+			d, err := e.devalue(fmt.Sprintf("%s[%d]", key, i), vv, opts)
 			if err != nil {
 				return nil, err
 			}
 			data = append(data, d...)
 		}
+	}
+	return data, nil
+}
+
+// devalueStruct encodes the fields of the struct v below key, flattening
+// the inline ones into it.
+func (e *encodeState) devalueStruct(key string, v reflect.Value) ([]byte, error) {
+	fields := e.opts.structFields(v.Type())
+
+	var data []byte
+	for _, f := range fields {
+		vf, ok := fieldByIndex(v, f.index, false)
+		if !ok {
+			continue
+		}
+
+		kk := key
+		if f.rest {
+			vf = withoutFieldKeys(vf, fields)
+		} else {
+			kk = f.key
+			if key != "" {
+				kk = fmt.Sprintf("%s.%s", key, kk)
+			}
+		}
+
+		d, err := e.devalue(kk, vf, f.opts)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, d...)
+	}
+	return data, nil
+}
+
+// withoutFieldKeys returns a copy of the map of a rest field without the
+// entries keyed like one of the other fields, which take precedence.
+func withoutFieldKeys(v reflect.Value, fields []structField) reflect.Value {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Map || v.IsNil() {
+		return v
+	}
+
+	taken := map[string]bool{}
+	for _, f := range fields {
+		if !f.rest {
+			taken[f.key] = true
+		}
+	}
+
+	m := reflect.MakeMap(v.Type())
+	iter := v.MapRange()
+	for iter.Next() {
+		if s, err := mapKeyString(iter.Key()); err == nil && taken[s] {
+			continue
+		}
+		m.SetMapIndex(iter.Key(), iter.Value())
+	}
+	return m
+}
+
+// scalar formats v when it is encoded as a single value.
+func (e *encodeState) scalar(v reflect.Value, opts tagOptions) (string, bool, error) {
+	if !v.IsValid() {
+		return "", false, nil
+	}
+
+	switch v.Type() {
+	case durationType:
+		return time.Duration(v.Int()).String(), true, nil
+	case timeType:
+		return v.Interface().(time.Time).Format(timeLayout(opts)), true, nil
+	}
+
+	if m, ok := textMarshaler(v); ok {
+		b, err := m.MarshalText()
+		return string(b), true, err
+	}
+
+	if s, ok := formatBasicType(v); ok {
+		return s, true, nil
+	}
+	return "", false, nil
+}
+
+// formatBasicType formats v of a bool, number or string kind the way
+// setBasicType parses it back, ignoring any String method of its type.
+func formatBasicType(v reflect.Value) (string, bool) {
+	switch v.Kind() {
 	case reflect.String:
-		fallthrough
-	case reflect.Int:
-		fallthrough
-	case reflect.Int8:
-		fallthrough
-	case reflect.Int16:
-		fallthrough
-	case reflect.Int32:
-		fallthrough
-	case reflect.Int64:
-		fallthrough
-	case reflect.Float32:
-		fallthrough
-	case reflect.Float64:
-		fallthrough
+		return v.String(), true
 	case reflect.Bool:
-		fallthrough
-	case reflect.Uint:
-		fallthrough
-	case reflect.Uint8:
-		fallthrough
-	case reflect.Uint16:
-		fallthrough
-	case reflect.Uint32:
-		fallthrough
-	case reflect.Uint64:
-		return toPropLineBytes(key, fmt.Sprint(v.Interface())), nil
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), true
 	}
-	return data, nil
+	return "", false
+}
+
+// join encodes the elements of the list v as a single value separated by
+// sep, for fields with the split option.
+func (e *encodeState) join(key string, v reflect.Value, sep string, opts tagOptions) ([]byte, error) {
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return nil, nil
+	}
+
+	elems := make([]string, v.Len())
+	for i := range elems {
+		ev := v.Index(i)
+		s, ok, err := e.hook(ev)
+		if !ok && err == nil {
+			for ev.Kind() == reflect.Ptr && !ev.IsNil() {
+				ev = ev.Elem()
+			}
+			s, ok, err = e.scalar(ev, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("properties: cannot split %s at key %q: %w", v.Type(), key, UnsupportedTypeError)
+		}
+		if strings.Contains(s, sep) {
+			return nil, fmt.Errorf("properties: element %q at key %q contains separator %q", s, key, sep)
+		}
+		elems[i] = s
+	}
+	return toPropLineBytes(key, strings.Join(elems, sep)), nil
 }
@@ -1,11 +1,16 @@
 package properties
 
 import (
+	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"log"
+	"net"
+	"net/netip"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMarshal__map(t *testing.T) {
@@ -164,3 +169,448 @@ func TestMarshal__complex_usages(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedData, data)
 }
+
+func TestMarshal__escape(t *testing.T) {
+	var m = map[string]string{
+		"a=b:c":   "x=y#z",
+		"k #1!":   " leading space",
+		"newline": "line1\nline2\r\n\tend \\",
+		"unicode": "中文\x01",
+		"invalid": "a\xffb\xc3",
+		"a\xfe":   "\uFFFD",
+	}
+
+	data, err := Marshal(m)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "a\\=b\\:c=x=y#z\n")
+	assert.Contains(t, string(data), "k\\ \\#1\\!=\\ leading space\n")
+	assert.Contains(t, string(data), "newline=line1\\nline2\\r\\n\\tend \\\\\n")
+	assert.Contains(t, string(data), "unicode=中文\\u0001\n")
+	assert.Contains(t, string(data), "invalid=a\xffb\xc3\n")
+
+	p, err := propsFromBytes(data, "")
+	assert.NoError(t, err)
+	assert.Equal(t, m, p.kv)
+}
+
+func TestMarshal__text_marshaler(t *testing.T) {
+	type S struct {
+		IP      net.IP           `properties:"ip"`
+		Addr    netip.Addr       `properties:"addr"`
+		Level   level            `properties:"level"`
+		Levels  []level          `properties:"levels"`
+		ByLevel map[level]string `properties:"by_level"`
+	}
+
+	var s = S{
+		IP:      net.ParseIP("10.0.0.1"),
+		Addr:    netip.MustParseAddr("::1"),
+		Level:   levelInfo,
+		Levels:  []level{levelDebug},
+		ByLevel: map[level]string{levelInfo: "x"},
+	}
+
+	expectedLines := []string{
+		"ip=10.0.0.1\n",
+		"addr=::1\n",
+		"level=info\n",
+		"levels[0]=debug\n",
+		"by_level.info=x\n",
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(expectedLines, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+
+	t.Run("marshal error", func(t *testing.T) {
+		_, err := Marshal(S{Level: level(7)})
+		assert.EqualError(t, err, "unknown level 7")
+	})
+}
+
+func TestMarshal__time(t *testing.T) {
+	type S struct {
+		Timeout  time.Duration   `properties:"timeout"`
+		Backoff  []time.Duration `properties:"backoff"`
+		Created  time.Time       `properties:"created"`
+		Birthday time.Time       `properties:"birthday,layout=2006-01-02"`
+		Expires  *time.Time      `properties:"expires,layout=RFC1123"`
+	}
+
+	expires := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	var s = S{
+		Timeout:  90 * time.Second,
+		Backoff:  []time.Duration{100 * time.Millisecond},
+		Created:  time.Date(2019, 6, 1, 8, 30, 0, 5e8, time.FixedZone("", 8*3600)),
+		Birthday: time.Date(1990, 12, 31, 0, 0, 0, 0, time.UTC),
+		Expires:  &expires,
+	}
+
+	expectedLines := []string{
+		"timeout=1m30s\n",
+		"backoff[0]=100ms\n",
+		"created=2019-06-01T08:30:00.5+08:00\n",
+		"birthday=1990-12-31\n",
+		"expires=Mon, 02 Jan 2006 15:04:05 UTC\n",
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(expectedLines, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s.Timeout, given.Timeout)
+	assert.Equal(t, s.Backoff, given.Backoff)
+	assert.True(t, s.Created.Equal(given.Created))
+	assert.Equal(t, s.Birthday, given.Birthday)
+	assert.True(t, s.Expires.Equal(*given.Expires))
+}
+
+func TestMarshal__split(t *testing.T) {
+	type S struct {
+		Hosts    []string        `properties:"hosts,split"`
+		Ports    []*int          `properties:"ports,split"`
+		Timeouts []time.Duration `properties:"timeouts,split=;"`
+		Levels   []level         `properties:"levels,split"`
+		Empty    []string        `properties:"empty,split"`
+		Nil      []string        `properties:"nil,split"`
+	}
+
+	port := 443
+	var s = S{
+		Hosts:    []string{"a.example", "b.example"},
+		Ports:    []*int{&port},
+		Timeouts: []time.Duration{time.Second, time.Minute},
+		Levels:   []level{levelDebug, levelInfo},
+		Empty:    []string{},
+	}
+
+	expectedLines := []string{
+		"hosts=a.example,b.example\n",
+		"ports=443\n",
+		"timeouts=1s;1m0s\n",
+		"levels=debug,info\n",
+		"empty=\n",
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(expectedLines, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s.Hosts, given.Hosts)
+	assert.Equal(t, s.Timeouts, given.Timeouts)
+	assert.Equal(t, s.Levels, given.Levels)
+
+	t.Run("separator in element", func(t *testing.T) {
+		_, err := Marshal(S{Hosts: []string{"a,b"}})
+		assert.EqualError(t, err, `properties: element "a,b" at key "hosts" contains separator ","`)
+	})
+
+	t.Run("non scalar element", func(t *testing.T) {
+		type S struct {
+			Pairs [][]int `properties:"pairs,split"`
+		}
+		_, err := Marshal(S{Pairs: [][]int{{1}}})
+		assert.True(t, errors.Is(err, UnsupportedTypeError))
+	})
+}
+
+func TestMarshal__array(t *testing.T) {
+	type P struct {
+		X int `properties:"x"`
+	}
+
+	type S struct {
+		Vec    [3]float64 `properties:"vec"`
+		Points [1]P       `properties:"points"`
+		Listed [2]string  `properties:"listed,split"`
+	}
+
+	var s = S{
+		Vec:    [3]float64{1.5, 2.5, 3.5},
+		Points: [1]P{{1}},
+		Listed: [2]string{"a", "b"},
+	}
+
+	expectedLines := []string{
+		"vec[0]=1.5\n",
+		"vec[1]=2.5\n",
+		"vec[2]=3.5\n",
+		"points[0].x=1\n",
+		"listed=a,b\n",
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(expectedLines, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+}
+
+func TestMarshal__nested_slices_and_maps(t *testing.T) {
+	type Route struct {
+		Path    string            `properties:"path"`
+		Headers map[string]string `properties:"headers"`
+	}
+
+	type S struct {
+		Matrix [][]int             `properties:"matrix"`
+		Routes []Route             `properties:"routes"`
+		Labels []map[string]string `properties:"labels"`
+		Groups map[string][]string `properties:"groups"`
+	}
+
+	var s = S{
+		Matrix: [][]int{{1, 5}, {7}},
+		Routes: []Route{{Path: "/a", Headers: map[string]string{"X-Id": "abc"}}},
+		Labels: []map[string]string{{"env": "prod"}},
+		Groups: map[string][]string{"admins": {"alice", "bob"}},
+	}
+
+	expectedLines := []string{
+		"matrix[0][0]=1\n",
+		"matrix[0][1]=5\n",
+		"matrix[1][0]=7\n",
+		"routes[0].path=/a\n",
+		"routes[0].headers.X-Id=abc\n",
+		"labels[0].env=prod\n",
+		"groups.admins[0]=alice\n",
+		"groups.admins[1]=bob\n",
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(expectedLines, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+}
+
+func TestMarshal__map_key_types(t *testing.T) {
+	type region string
+
+	type S struct {
+		Shards    map[int]string    `properties:"shards"`
+		Endpoints map[region]string `properties:"endpoints"`
+		Flags     map[bool]int      `properties:"flags"`
+	}
+
+	var s = S{
+		Shards:    map[int]string{10: "c", 2: "b", -1: "a"},
+		Endpoints: map[region]string{"us": "u", "eu": "e"},
+		Flags:     map[bool]int{true: 1, false: 0},
+	}
+
+	expectedLines := []string{
+		"shards.-1=a\n",
+		"shards.2=b\n",
+		"shards.10=c\n",
+		"endpoints.eu=e\n",
+		"endpoints.us=u\n",
+		"flags.false=0\n",
+		"flags.true=1\n",
+	}
+
+	for i := 0; i < 5; i++ {
+		data, err := Marshal(s)
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Join(expectedLines, ""), string(data))
+	}
+
+	data, _ := Marshal(s)
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+
+	t.Run("duration and time keys", func(t *testing.T) {
+		type T struct {
+			Timeouts map[time.Duration]string `properties:"timeouts"`
+			Events   map[time.Time]int        `properties:"events"`
+		}
+
+		at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		s := T{
+			Timeouts: map[time.Duration]string{5 * time.Second: "a", 90 * time.Millisecond: "b"},
+			Events:   map[time.Time]int{at: 1},
+		}
+		data, err := Marshal(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "timeouts.90ms=b\ntimeouts.5s=a\nevents.2024-01-02T03\\:04\\:05Z=1\n", string(data))
+
+		var given T
+		assert.NoError(t, Unmarshal(data, &given))
+		assert.Equal(t, s, given)
+	})
+
+	t.Run("unsupported key", func(t *testing.T) {
+		_, err := Marshal(map[[2]int]string{{1, 2}: "x"})
+		assert.True(t, errors.Is(err, UnsupportedTypeError))
+	})
+}
+
+func TestMarshal__quoted_map_keys(t *testing.T) {
+	type S struct {
+		Weights map[string]int `properties:"weights"`
+	}
+
+	s := S{Weights: map[string]int{"api.example.com": 3, "plain": 1, `say "hi"`: 2, "a[0]": 4}}
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"weights.\"a[0]\"=4\n",
+		"weights.\"api.example.com\"=3\n",
+		"weights.plain=1\n",
+		"weights.\"say\\ \"\"hi\"\"\"=2\n",
+	}, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+}
+
+func TestMarshal__embedded(t *testing.T) {
+	type Base struct {
+		Host string `properties:"host"`
+		Port int    `properties:"port"`
+	}
+
+	type tls struct {
+		Cert string `properties:"cert"`
+	}
+
+	type API struct {
+		Base
+		tls
+		Path string `properties:"path"`
+	}
+
+	type Cache struct {
+		Base Base `properties:"base,squash"`
+		Size int  `properties:"size"`
+	}
+
+	type S struct {
+		API   API   `properties:"api"`
+		Cache Cache `properties:"cache"`
+	}
+
+	s := S{
+		API:   API{Base{"a.example", 8080}, tls{"tls.pem"}, "/v1"},
+		Cache: Cache{Base{"c.example", 6379}, 10},
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"api.host=a.example\n",
+		"api.port=8080\n",
+		"api.cert=tls.pem\n",
+		"api.path=/v1\n",
+		"cache.host=c.example\n",
+		"cache.port=6379\n",
+		"cache.size=10\n",
+	}, ""), string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+}
+
+func TestMarshal__naming(t *testing.T) {
+	type S struct {
+		MaxConns int
+		UserID   string
+		Host     string `properties:"hostname"`
+	}
+
+	data, err := Marshal(S{5, "u", "h"}, WithNaming(SnakeCase))
+	assert.NoError(t, err)
+	assert.Equal(t, "max_conns=5\nuser_id=u\nhostname=h\n", string(data))
+
+	var buf bytes.Buffer
+	assert.NoError(t, NewEncoder(&buf, WithNaming(CamelCase)).Encode(S{5, "u", "h"}))
+	assert.Equal(t, "maxConns=5\nuserID=u\nhostname=h\n", buf.String())
+
+	var given S
+	assert.NoError(t, NewDecoder(&buf, WithNaming(CamelCase)).Decode(&given))
+	assert.Equal(t, S{5, "u", "h"}, given)
+}
+
+func TestMarshal__embedded_shadowing(t *testing.T) {
+	type pBase struct {
+		Host string `properties:"host"`
+		Port int    `properties:"port"`
+	}
+
+	type Outer struct {
+		pBase
+		Host  string            `properties:"host"`
+		Extra map[string]string `properties:",inline"`
+	}
+
+	s := Outer{
+		pBase: pBase{"inner", 1},
+		Host:  "outer",
+		Extra: map[string]string{"name": "a", "host": "x", "port": "2"},
+	}
+
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "port=1\nhost=outer\nname=a\n", string(data))
+
+	var given Outer
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, Outer{
+		pBase: pBase{Port: 1},
+		Host:  "outer",
+		Extra: map[string]string{"name": "a"},
+	}, given)
+}
+
+type color int
+
+func (c color) String() string {
+	return [...]string{"red", "green"}[c]
+}
+
+func TestMarshal__stringer(t *testing.T) {
+	type S struct {
+		C  color   `properties:"c"`
+		Cs []color `properties:"cs,split"`
+		F  float32 `properties:"f"`
+	}
+
+	s := S{C: 1, Cs: []color{0, 1}, F: 0.1}
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "c=1\ncs=0,1\nf=0.1\n", string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+}
+
+func TestMarshal__stringer_map_keys(t *testing.T) {
+	type S struct {
+		M map[color]int `properties:"m"`
+	}
+
+	s := S{M: map[color]int{1: 2, 0: 1}}
+	data, err := Marshal(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "m.0=1\nm.1=2\n", string(data))
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, s, given)
+}
@@ -0,0 +1,95 @@
+package properties
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A Naming derives the key of a struct field without a key in its tag from
+// the name of the field.
+type Naming func(field string) string
+
+var (
+	// AsIs keys fields by their name, e.g. "MaxConns". It is the default.
+	AsIs Naming = func(field string) string { return field }
+	// Lower keys fields by their lowercased name, e.g. "maxconns".
+	Lower Naming = strings.ToLower
+	// SnakeCase keys fields in snake case, e.g. "max_conns".
+	SnakeCase Naming = func(field string) string { return joinWords(field, "_") }
+	// KebabCase keys fields in kebab case, e.g. "max-conns".
+	KebabCase Naming = func(field string) string { return joinWords(field, "-") }
+	// CamelCase keys fields in lower camel case, e.g. "maxConns" or "userID".
+	CamelCase Naming = camelCase
+)
+
+// WithNaming makes untagged fields, and fields whose tag has options but no
+// key, keyed by n rather than by their name, when decoding and encoding.
+func WithNaming(n Naming) Option {
+	return func(o *options) {
+		o.naming = n
+	}
+}
+
+// fieldKey returns the key of the struct field called field, tagged with
+// name.
+func (o *options) fieldKey(field, name string) string {
+	if name != "" {
+		return name
+	}
+	if o.naming != nil {
+		return o.naming(field)
+	}
+	return field
+}
+
+// splitWords splits the identifier s into words at case changes, keeping
+// acronyms, their plurals and trailing digits whole: "HTTPServer2Port"
+// becomes "HTTP", "Server2" and "Port", and "URLsByID" "URLs", "By" and
+// "ID".
+func splitWords(s string) []string {
+	rs := []rune(s)
+	var words []string
+	start := 0
+	for i := 1; i < len(rs); i++ {
+		prev, cur := rs[i-1], rs[i]
+		switch {
+		case cur == '_' || cur == '-':
+		case prev == '_' || prev == '-':
+		case unicode.IsUpper(cur) && !unicode.IsUpper(prev):
+		case unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(rs) && unicode.IsLower(rs[i+1]) &&
+			!isPluralS(rs, i+1):
+		default:
+			continue
+		}
+		words = appendWord(words, rs[start:i])
+		start = i
+	}
+	return appendWord(words, rs[start:])
+}
+
+// isPluralS reports whether rs[i] is a lone 's' ending the uppercase run
+// before it, as in "URLs".
+func isPluralS(rs []rune, i int) bool {
+	return rs[i] == 's' && (i+1 == len(rs) || !unicode.IsLower(rs[i+1]))
+}
+
+func appendWord(words []string, w []rune) []string {
+	word := strings.Trim(string(w), "_-")
+	if word == "" {
+		return words
+	}
+	return append(words, word)
+}
+
+func joinWords(field, sep string) string {
+	return strings.ToLower(strings.Join(splitWords(field), sep))
+}
+
+func camelCase(field string) string {
+	words := splitWords(field)
+	if len(words) == 0 {
+		return ""
+	}
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
@@ -0,0 +1,32 @@
+package properties
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNaming(t *testing.T) {
+	cases := []struct {
+		field                    string
+		lower, snake, kebab, cam string
+	}{
+		{"Host", "host", "host", "host", "host"},
+		{"MaxConns", "maxconns", "max_conns", "max-conns", "maxConns"},
+		{"UserID", "userid", "user_id", "user-id", "userID"},
+		{"HTTPServer2Port", "httpserver2port", "http_server2_port", "http-server2-port", "httpServer2Port"},
+		{"Read_Timeout", "read_timeout", "read_timeout", "read-timeout", "readTimeout"},
+		{"URLs", "urls", "urls", "urls", "urls"},
+		{"AllowedIPs", "allowedips", "allowed_ips", "allowed-ips", "allowedIPs"},
+		{"IDs", "ids", "ids", "ids", "ids"},
+		{"URLsByID", "urlsbyid", "urls_by_id", "urls-by-id", "urlsByID"},
+		{"HTTPService", "httpservice", "http_service", "http-service", "httpService"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.field, AsIs(c.field))
+		assert.Equal(t, c.lower, Lower(c.field), c.field)
+		assert.Equal(t, c.snake, SnakeCase(c.field), c.field)
+		assert.Equal(t, c.kebab, KebabCase(c.field), c.field)
+		assert.Equal(t, c.cam, CamelCase(c.field), c.field)
+	}
+}
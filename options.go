@@ -0,0 +1,68 @@
+package properties
+
+// An Option configures how Unmarshal, UnmarshalKV, UnmarshalKey and Decoder
+// decode .properties config, and, for the options that say so, how Marshal
+// and Encoder encode it.
+type Option func(*options)
+
+type options struct {
+	allErrors           bool
+	disallowUnknownKeys bool
+	metadata            *Metadata
+	inferTypes          bool
+	naming              Naming
+	fold                func(string) string
+	preferred           func(string) bool
+	onKeyConflict       func(used string, ignored []string)
+	decodeHooks         []DecodeHook
+	encodeHooks         []EncodeHook
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// AllErrors makes decoding continue past keys that fail to decode and
+// return all the failures at once as a *MultiError.
+func AllErrors() Option {
+	return func(o *options) {
+		o.allErrors = true
+	}
+}
+
+// DisallowUnknownKeys makes decoding fail with an *UnknownKeyError when the
+// input holds keys that no field consumes.
+func DisallowUnknownKeys() Option {
+	return func(o *options) {
+		o.disallowUnknownKeys = true
+	}
+}
+
+// Metadata describes how the input was matched against the target value.
+type Metadata struct {
+	Keys   []string // keys consumed by some field, sorted
+	Unused []string // keys no field consumed, sorted
+	Unset  []string // Go field paths no key matched: left as they were, but slices and maps set empty
+}
+
+// WithMetadata makes decoding fill md with the keys it used and ignored and
+// the fields it left unset. When decoding fails, md describes the work done
+// up to the failure.
+func WithMetadata(md *Metadata) Option {
+	return func(o *options) {
+		o.metadata = md
+	}
+}
+
+// InferTypes makes decoding into interface{} values store numbers as int64
+// or float64 and true and false as bools, rather than keeping every value a
+// string.
+func InferTypes() Option {
+	return func(o *options) {
+		o.inferTypes = true
+	}
+}
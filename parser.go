@@ -0,0 +1,258 @@
+package properties
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// lineReader splits .properties input into logical lines following the
+// grammar of java.util.Properties.load: blank lines and lines starting with
+// '#' or '!' are skipped, and a line ending with an odd number of
+// backslashes continues on the next line.
+type lineReader struct {
+	r    *bufio.Reader
+	name string
+	n    int // natural lines read so far
+
+	// segs records where each natural line of the current logical line
+	// begins, for error reporting.
+	segs []segment
+}
+
+type segment struct {
+	line  int    // 1-based line number
+	start int    // offset of the segment in the logical line
+	col   int    // byte offset of the segment in the natural line
+	text  string // the natural line
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReader(r), name: nameOf(r)}
+}
+
+// nameOf returns the file name of r when it has one, as *os.File does.
+func nameOf(r io.Reader) string {
+	if f, ok := r.(interface{ Name() string }); ok {
+		return f.Name()
+	}
+	return ""
+}
+
+// readNaturalLine reads up to the next "\n", "\r" or "\r\n" terminator.
+func (lr *lineReader) readNaturalLine() (string, error) {
+	lr.n++
+	var sb strings.Builder
+	for {
+		c, err := lr.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && sb.Len() > 0 {
+				return sb.String(), nil
+			}
+			return sb.String(), err
+		}
+		switch c {
+		case '\n':
+			return sb.String(), nil
+		case '\r':
+			if next, err := lr.r.Peek(1); err == nil && next[0] == '\n' {
+				_, _ = lr.r.ReadByte()
+			}
+			return sb.String(), nil
+		}
+		sb.WriteByte(c)
+	}
+}
+
+// readLine returns the next logical line with leading whitespace removed and
+// continuation lines joined. It returns io.EOF when the input is exhausted.
+func (lr *lineReader) readLine() (string, error) {
+	for {
+		raw, err := lr.readNaturalLine()
+		if err != nil {
+			return "", err
+		}
+
+		line := trimLeftSpace(raw)
+		// skip empty line
+		if len(line) == 0 {
+			continue
+		}
+		// skip comment line
+		if line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		lr.segs = append(lr.segs[:0], segment{lr.n, 0, len(raw) - len(line), raw})
+		for continues(line) {
+			line = line[:len(line)-1]
+			next, err := lr.readNaturalLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			trimmed := trimLeftSpace(next)
+			lr.segs = append(lr.segs, segment{lr.n, len(line), len(next) - len(trimmed), next})
+			line += trimmed
+		}
+		return line, nil
+	}
+}
+
+// errorAt returns a *ParseError for the byte at offset off of the current
+// logical line.
+func (lr *lineReader) errorAt(off int, msg string) *ParseError {
+	seg := lr.segs[0]
+	for _, s := range lr.segs {
+		if s.start <= off {
+			seg = s
+		}
+	}
+
+	col := seg.col + off - seg.start
+	if col > len(seg.text) {
+		col = len(seg.text)
+	}
+	return &ParseError{
+		Filename: lr.name,
+		Line:     seg.line,
+		Column:   utf8.RuneCountInString(seg.text[:col]) + 1,
+		Text:     seg.text,
+		Msg:      msg,
+		Err:      InvalidPropBytes,
+	}
+}
+
+// continues reports whether line ends with an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\f'
+}
+
+func trimLeftSpace(s string) string {
+	i := 0
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return s[i:]
+}
+
+// splitKeyValue splits a logical line into its raw key and value. The key
+// ends at the first unescaped '=', ':' or whitespace; the separator may be
+// surrounded by whitespace, and a line without a value yields "".
+func splitKeyValue(line string) (string, string) {
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		if c == '\\' {
+			i += 2
+			continue
+		}
+		// NOTE: separators within a quoted segment belong to the key
+		if i == 0 || line[i-1] == '.' {
+			if n := quotedLen(line[i:]); n > 0 {
+				i += n
+				continue
+			}
+		}
+		if c == '=' || c == ':' || isSpace(c) {
+			break
+		}
+		i++
+	}
+	if i > len(line) {
+		i = len(line)
+	}
+
+	key, rest := line[:i], trimLeftSpace(line[i:])
+	if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+		rest = trimLeftSpace(rest[1:])
+	}
+	return key, rest
+}
+
+// syntaxError reports a malformed escape at offset off of an unescaped
+// string.
+type syntaxError struct {
+	off int
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+// unescape decodes the escape sequences of s: \t, \n, \r, \f, \uXXXX
+// (combining UTF-16 surrogate pairs), and any other escaped character
+// standing for itself, so that "a\=b" becomes "a=b".
+func unescape(s string) (string, error) {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch c = s[i]; c {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			r, ok := unquoteUnicode(s[i+1:])
+			if !ok {
+				return "", &syntaxError{i - 1, `malformed \uXXXX escape`}
+			}
+			i += 4
+			if utf16.IsSurrogate(r) && strings.HasPrefix(s[i+1:], "\\u") {
+				if r2, ok := unquoteUnicode(s[i+3:]); ok {
+					if dr := utf16.DecodeRune(r, r2); dr != utf8.RuneError {
+						r = dr
+						i += 6
+					}
+				}
+			}
+			if utf16.IsSurrogate(r) {
+				r = utf8.RuneError
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// unquoteUnicode decodes the four hex digits following a \u.
+func unquoteUnicode(s string) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[:4], 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return rune(n), true
+}
@@ -0,0 +1,78 @@
+package properties
+
+import (
+	"reflect"
+)
+
+// Unmarshaler is implemented by types that decode themselves from their
+// whole subtree of keys rather than from a single value.
+type Unmarshaler interface {
+	UnmarshalProperties(sub *Properties) error
+}
+
+// Marshaler is implemented by types that encode themselves into a subtree
+// of keys. The returned keys are relative to the key of the value.
+type Marshaler interface {
+	MarshalProperties() (map[string]string, error)
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+)
+
+// Properties is the subtree of keys below the key of a value implementing
+// Unmarshaler, with the key prefix removed: for a field keyed "pool", the
+// key "pool.size" reads as "size".
+type Properties struct {
+	p *props
+}
+
+// Get returns the value of key.
+func (ps *Properties) Get(key string) (string, bool) {
+	return ps.p.get(key)
+}
+
+// Keys returns the keys of the subtree, sorted.
+func (ps *Properties) Keys() []string {
+	return ps.p.keys()
+}
+
+// Sub returns the subtree of keys below prefix.
+func (ps *Properties) Sub(prefix string) *Properties {
+	return &Properties{ps.p.subprops(prefix)}
+}
+
+// Unmarshal decodes the subtree into the value pointed to by v, with the
+// options of the ongoing decoding.
+func (ps *Properties) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return InvalidUnmarshalError
+	}
+	return ps.p.value("", rv.Elem())
+}
+
+// propertiesUnmarshaler returns v as an Unmarshaler when its pointer
+// implements it.
+func propertiesUnmarshaler(v reflect.Value) (Unmarshaler, bool) {
+	if v.Kind() == reflect.Ptr || !v.CanAddr() || !v.Addr().Type().Implements(unmarshalerType) {
+		return nil, false
+	}
+	return v.Addr().Interface().(Unmarshaler), true
+}
+
+// propertiesMarshaler returns v as a Marshaler when v or, for an addressable
+// v, its pointer implements it.
+func propertiesMarshaler(v reflect.Value) (Marshaler, bool) {
+	if !v.IsValid() || v.Kind() == reflect.Ptr {
+		return nil, false
+	}
+	if v.Type().Implements(marshalerType) {
+		return v.Interface().(Marshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		return v.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
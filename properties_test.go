@@ -0,0 +1,104 @@
+package properties
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+type pool struct {
+	Min   int
+	Max   int
+	Spare int
+	Tags  []string
+}
+
+func (p *pool) UnmarshalProperties(sub *Properties) error {
+	var size struct {
+		Min int `properties:"min"`
+		Max int `properties:"max"`
+	}
+	if err := sub.Sub("size").Unmarshal(&size); err != nil {
+		return err
+	}
+	if size.Min > size.Max {
+		return errors.New("pool: min exceeds max")
+	}
+	p.Min, p.Max, p.Spare = size.Min, size.Max, size.Max-size.Min
+
+	for _, k := range sub.Keys() {
+		if k != "size.min" && k != "size.max" {
+			v, _ := sub.Get(k)
+			p.Tags = append(p.Tags, k+"="+v)
+		}
+	}
+	return nil
+}
+
+func (p pool) MarshalProperties() (map[string]string, error) {
+	return map[string]string{
+		"size.min": strconv.Itoa(p.Min),
+		"size.max": strconv.Itoa(p.Max),
+	}, nil
+}
+
+func TestUnmarshalKV__properties_unmarshaler(t *testing.T) {
+	type S struct {
+		Name  string          `properties:"name"`
+		Pool  pool            `properties:"pool"`
+		Pools map[string]pool `properties:"pools"`
+		Empty *pool           `properties:"empty"`
+	}
+
+	var input = map[string]string{
+		"name":             "app",
+		"pool.size.min":    "2",
+		"pool.size.max":    "10",
+		"pool.label":       "x",
+		"pools.a.size.min": "1",
+		"pools.a.size.max": "1",
+	}
+
+	var given S
+	assert.NoError(t, UnmarshalKV(input, &given, DisallowUnknownKeys()))
+	assert.Equal(t, pool{Min: 2, Max: 10, Spare: 8, Tags: []string{"label=x"}}, given.Pool)
+	assert.Equal(t, map[string]pool{"a": {Min: 1, Max: 1}}, given.Pools)
+	assert.Equal(t, &pool{}, given.Empty)
+
+	t.Run("errors", func(t *testing.T) {
+		var input = map[string]string{
+			"pool.size.min":    "x",
+			"pools.a.size.min": "3",
+			"pools.a.size.max": "1",
+		}
+
+		var given S
+		err := UnmarshalKV(input, &given, AllErrors())
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		assert.Len(t, me.Errors, 2)
+		assert.Equal(t, "pool.size.min", me.Errors[0].(*UnmarshalTypeError).Key)
+		assert.Equal(t, "Pool.Min", me.Errors[0].(*UnmarshalTypeError).Field)
+		assert.EqualError(t, me.Errors[1], "pool: min exceeds max")
+	})
+}
+
+func TestMarshal__properties_marshaler(t *testing.T) {
+	type S struct {
+		Name  string  `properties:"name"`
+		Pool  pool    `properties:"pool"`
+		Pools []*pool `properties:"pools"`
+	}
+
+	data, err := Marshal(S{Name: "app", Pool: pool{Min: 2, Max: 10}, Pools: []*pool{{Min: 1, Max: 3}}})
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(
+		"name=app\n",
+		"pool.size.max=10\n",
+		"pool.size.min=2\n",
+		"pools[0].size.max=3\n",
+		"pools[0].size.min=1\n",
+	), string(data))
+}
@@ -0,0 +1,171 @@
+package properties
+
+import (
+	"sort"
+	"strings"
+)
+
+// RelaxedBinding makes keys match whatever their case and their '-' and '_'
+// separators, so that a field keyed "max-pool-size" reads any of
+// max-pool-size, maxPoolSize, MAX_POOL_SIZE or max_pool_size. Quoted
+// segments still match exactly.
+//
+// Of the spellings of one key found in the same input, the one in lowercase
+// without underscores is used, e.g. "max-pool-size", or else the lexically
+// first; the others are reported to the hook given by OnKeyConflict and
+// otherwise ignored.
+func RelaxedBinding() Option {
+	return func(o *options) {
+		o.fold = relaxedFold
+		o.preferred = isKebabSpelling
+	}
+}
+
+// CaseInsensitiveKeys makes keys match whatever their case, so that a
+// field keyed "db.host" reads DB.Host. Quoted segments still match exactly.
+// Of the spellings of one key found in the same input, the lowercase one is
+// used, or else the lexically first. RelaxedBinding already implies it.
+func CaseInsensitiveKeys() Option {
+	return func(o *options) {
+		if o.fold == nil {
+			o.fold = strings.ToLower
+			o.preferred = isLowerSpelling
+		}
+	}
+}
+
+// OnKeyConflict makes fn called, in order of used key, for every key written
+// in several spellings that match each other with RelaxedBinding or
+// CaseInsensitiveKeys. It is passed the spelling used and the ignored ones.
+func OnKeyConflict(fn func(used string, ignored []string)) Option {
+	return func(o *options) {
+		o.onKeyConflict = fn
+	}
+}
+
+var separatorRemover = strings.NewReplacer("-", "", "_", "")
+
+func relaxedFold(s string) string {
+	return strings.ToLower(separatorRemover.Replace(s))
+}
+
+// canonicalKey returns the form of key k that matching compares: fold
+// applied to all but its quoted segments.
+func canonicalKey(k string, fold func(string) string) string {
+	var sb strings.Builder
+	for i := 0; i < len(k); {
+		if n := quotedLen(k[i:]); n > 0 {
+			sb.WriteString(k[i : i+n])
+			i += n
+			continue
+		}
+		j := strings.IndexByte(k[i:], '.')
+		if j == -1 {
+			j = len(k)
+		} else {
+			j += i + 1
+		}
+		sb.WriteString(fold(k[i:j]))
+		i = j
+	}
+	return sb.String()
+}
+
+// fold returns how keys are folded before matching, or nil when they match
+// exactly.
+func (p *props) fold() func(string) string {
+	if p.d == nil {
+		return nil
+	}
+	return p.d.opts.fold
+}
+
+// lookup returns the key of the view that k matches.
+func (p *props) lookup(k string) (string, bool) {
+	if _, ok := p.kv[k]; ok {
+		return k, true
+	}
+	fold := p.fold()
+	if fold == nil {
+		return "", false
+	}
+
+	if p.index == nil {
+		p.index = make(map[string]string, len(p.kv))
+		for kk := range p.kv {
+			p.index[canonicalKey(kk, fold)] = kk
+		}
+	}
+	kk, ok := p.index[canonicalKey(k, fold)]
+	return kk, ok
+}
+
+// cutPrefix returns the rest of key k after the prefix it matches, which
+// ends with a '.' or a '['.
+func (p *props) cutPrefix(k, prefix string) (string, bool) {
+	if strings.HasPrefix(k, prefix) {
+		return k[len(prefix):], true
+	}
+	fold := p.fold()
+	if fold == nil {
+		return "", false
+	}
+
+	sep := prefix[len(prefix)-1]
+	want := canonicalKey(prefix[:len(prefix)-1], fold)
+	for i := 0; i < len(k); i++ {
+		if k[i] == sep && canonicalKey(k[:i], fold) == want {
+			return k[i+1:], true
+		}
+	}
+	return "", false
+}
+
+// resolveConflicts returns a view keeping a single one of the keys that
+// match each other, and reports the others to the OnKeyConflict hook.
+func (p *props) resolveConflicts() *props {
+	fold, preferred := p.fold(), p.d.opts.preferred
+	groups := map[string][]string{}
+	for k := range p.kv {
+		ck := canonicalKey(k, fold)
+		groups[ck] = append(groups[ck], k)
+	}
+
+	kv := make(map[string]string, len(groups))
+	var conflicts [][]string
+	for _, keys := range groups {
+		sort.Slice(keys, func(i, j int) bool {
+			pi, pj := preferred(keys[i]), preferred(keys[j])
+			if pi != pj {
+				return pi
+			}
+			return keys[i] < keys[j]
+		})
+		kv[keys[0]] = p.kv[keys[0]]
+		if len(keys) > 1 {
+			conflicts = append(conflicts, keys)
+		}
+	}
+
+	if fn := p.d.opts.onKeyConflict; fn != nil {
+		sort.Slice(conflicts, func(i, j int) bool {
+			return conflicts[i][0] < conflicts[j][0]
+		})
+		for _, keys := range conflicts {
+			fn(keys[0], keys[1:])
+		}
+	}
+	return &props{kv: kv, prefix: p.prefix, d: p.d}
+}
+
+// isKebabSpelling reports whether k is in lowercase without underscores, as
+// kebab case keys are, for RelaxedBinding.
+func isKebabSpelling(k string) bool {
+	return isLowerSpelling(k) && !strings.Contains(k, "_")
+}
+
+// isLowerSpelling reports whether k is in lowercase, for
+// CaseInsensitiveKeys.
+func isLowerSpelling(k string) bool {
+	return k == strings.ToLower(k)
+}
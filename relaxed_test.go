@@ -0,0 +1,14 @@
+package properties
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCanonicalKey(t *testing.T) {
+	for _, k := range []string{"max-pool-size", "maxPoolSize", "MAX_POOL_SIZE", "max_pool_size"} {
+		assert.Equal(t, "maxpoolsize", canonicalKey(k, relaxedFold), k)
+	}
+	assert.Equal(t, "db.pools[0].maxsize", canonicalKey("DB.Pools[0].max-size", relaxedFold))
+	assert.Equal(t, `hosts."A-B.c".weight`, canonicalKey(`Hosts."A-B.c".Weight`, relaxedFold))
+}
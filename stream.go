@@ -0,0 +1,48 @@
+package properties
+
+import (
+	"io"
+)
+
+// A Decoder reads and decodes .properties config from an input stream.
+type Decoder struct {
+	r    io.Reader
+	opts []Option
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	return &Decoder{r: r, opts: opts}
+}
+
+// Decode reads the remaining input until EOF and stores the result in the
+// value pointed to by v, the same way Unmarshal does.
+func (dec *Decoder) Decode(v interface{}) error {
+	p, err := propsFromReader(dec.r, "")
+	if err != nil {
+		return err
+	}
+	return p.unmarshal(v, dec.opts...)
+}
+
+// An Encoder writes .properties config to an output stream.
+type Encoder struct {
+	w    io.Writer
+	opts []Option
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	return &Encoder{w: w, opts: opts}
+}
+
+// Encode writes the .properties encoding of v to the stream, the same way
+// Marshal does.
+func (enc *Encoder) Encode(v interface{}) error {
+	data, err := marshal(v, enc.opts...)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(data)
+	return err
+}
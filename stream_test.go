@@ -0,0 +1,58 @@
+package properties
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDecoder(t *testing.T) {
+	type A struct {
+		A string `properties:"a"`
+		B []int  `properties:"b"`
+	}
+
+	t.Run("from reader", func(t *testing.T) {
+		var given A
+		err := NewDecoder(strings.NewReader("a=hello\nb[0]=1\nb[1]=2\n")).Decode(&given)
+		assert.NoError(t, err)
+		assert.Equal(t, A{A: "hello", B: []int{1, 2}}, given)
+	})
+
+	t.Run("from fs.FS", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"conf/app.properties": {Data: []byte("a = world\n")},
+		}
+
+		f, err := fsys.Open("conf/app.properties")
+		assert.NoError(t, err)
+		defer f.Close()
+
+		var given A
+		assert.NoError(t, NewDecoder(f).Decode(&given))
+		assert.Equal(t, A{A: "world", B: []int{}}, given)
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		var given A
+		err := NewDecoder(strings.NewReader("a=hello")).Decode(given)
+		assert.Equal(t, InvalidUnmarshalError, err)
+	})
+}
+
+func TestEncoder(t *testing.T) {
+	type A struct {
+		A string `properties:"a"`
+		B []int  `properties:"b"`
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, NewEncoder(&buf).Encode(A{A: "hello", B: []int{1, 2}}))
+	assert.Equal(t, "a=hello\nb[0]=1\nb[1]=2\n", buf.String())
+
+	var given A
+	assert.NoError(t, NewDecoder(&buf).Decode(&given))
+	assert.Equal(t, A{A: "hello", B: []int{1, 2}}, given)
+}
@@ -1,6 +1,9 @@
 package properties
 
-import "strings"
+import (
+	"reflect"
+	"strings"
+)
 
 const tagName = "properties"
 
@@ -13,22 +16,67 @@ func parseTag(tag string) (string, tagOptions) {
 	return tag, tagOptions("")
 }
 
+// isInline reports whether the struct field tf, tagged with name and opts,
+// shares the key space of its parent: an untagged embedded struct, or any
+// field with the inline option, also spelled squash.
+func isInline(tf reflect.StructField, name string, opts tagOptions) bool {
+	if opts.Contains("inline") || opts.Contains("squash") {
+		return true
+	}
+	return tf.Anonymous && name == "" && isNested(tf.Type)
+}
+
 func (o tagOptions) Contains(optionName string) bool {
-	if len(o) == 0 {
-		return false
+	for _, opt := range o.split() {
+		if opt == optionName {
+			return true
+		}
 	}
+	return false
+}
+
+// Get returns the value of the option written as "name=value".
+func (o tagOptions) Get(optionName string) (string, bool) {
+	for _, opt := range o.split() {
+		if strings.HasPrefix(opt, optionName+"=") {
+			return opt[len(optionName)+1:], true
+		}
+	}
+	return "", false
+}
+
+// splitSep returns the delimiter of a list value given by the split option:
+// a comma for a bare split, or the value of split=, e.g. split=;.
+func (o tagOptions) splitSep() (string, bool) {
+	if o.Contains("split") {
+		return ",", true
+	}
+	if sep, ok := o.Get("split"); ok && sep != "" {
+		return sep, true
+	}
+	return "", false
+}
+
+// split splits the options on commas, except that the default= option runs
+// to the end of the tag so that its value may contain commas, e.g.
+// `properties:"hosts,default=a.example,b.example"`.
+func (o tagOptions) split() []string {
+	var opts []string
 
 	s := string(o)
 	for s != "" {
+		if strings.HasPrefix(s, "default=") {
+			opts = append(opts, s)
+			break
+		}
+
 		var next string
 		i := strings.Index(s, ",")
 		if i >= 0 {
 			s, next = s[:i], s[i+1:]
 		}
-		if s == optionName {
-			return true
-		}
+		opts = append(opts, s)
 		s = next
 	}
-	return false
+	return opts
 }
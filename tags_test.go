@@ -0,0 +1,24 @@
+package properties
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTagOptions(t *testing.T) {
+	name, opts := parseTag("hosts,required,layout=2006-01-02,default=a,required")
+	assert.Equal(t, "hosts", name)
+	assert.True(t, opts.Contains("required"))
+	assert.False(t, opts.Contains("layout"))
+
+	layout, ok := opts.Get("layout")
+	assert.True(t, ok)
+	assert.Equal(t, "2006-01-02", layout)
+
+	def, ok := opts.Get("default")
+	assert.True(t, ok)
+	assert.Equal(t, "a,required", def)
+
+	_, ok = opts.Get("split")
+	assert.False(t, ok)
+}
@@ -0,0 +1,48 @@
+package properties
+
+import (
+	"reflect"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// timeLayouts names the layouts of the time package, so that layouts with
+// commas, which cannot be written in a tag, can still be used, e.g.
+// `properties:"expires,layout=RFC1123"`.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+	"StampMilli":  time.StampMilli,
+	"StampMicro":  time.StampMicro,
+	"StampNano":   time.StampNano,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// timeLayout returns the layout given by the layout= option, RFC 3339 by
+// default.
+func timeLayout(opts tagOptions) string {
+	layout, ok := opts.Get("layout")
+	if !ok {
+		return time.RFC3339Nano
+	}
+	if l, ok := timeLayouts[layout]; ok {
+		return l
+	}
+	return layout
+}
@@ -1,44 +1,153 @@
 package properties
 
 import (
-	"bufio"
 	"bytes"
+	"encoding"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func unmarshalKV(kv map[string]string, v interface{}) error {
+func unmarshalKV(kv map[string]string, v interface{}, opts ...Option) error {
 	p := &props{kv: kv}
-	return p.unmarshal(v)
+	return p.unmarshal(v, opts...)
 }
 
 type props struct {
 	kv map[string]string
+
+	// prefix is the full key path of this view, e.g. "servers[2]." for the
+	// subprops of an element, used in error messages.
+	prefix string
+	// orig maps the keys of the view to their full key paths as spelled in
+	// the input, when they are matched with RelaxedBinding.
+	orig map[string]string
+	// index maps the canonical forms of the keys to the keys, built by
+	// lookup for RelaxedBinding.
+	index map[string]string
+	d     *decodeState
+}
+
+// decodeState is shared by all the views of one decoding.
+type decodeState struct {
+	opts options
+
+	// fields is the Go field path of the value being decoded.
+	fields []field
+	// errs collects the failures when decoding with AllErrors.
+	errs []error
+	// missing lists the absent keys of required fields.
+	missing []string
+	// used records the full keys whose values were consumed.
+	used map[string]bool
+	// unset lists the Go field paths that no key matched.
+	unset []string
+}
+
+// usedKeys returns the keys of kv that were consumed, sorted.
+func (d *decodeState) usedKeys(kv map[string]string) []string {
+	var keys []string
+	for k := range kv {
+		if d.used[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unusedKeys returns the keys of kv that were never consumed, sorted.
+func (d *decodeState) unusedKeys(kv map[string]string) []string {
+	var keys []string
+	for k := range kv {
+		if !d.used[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// saveError records err and returns nil when decoding with AllErrors, so
+// that the caller carries on; otherwise it returns err.
+func (d *decodeState) saveError(err error) error {
+	if err == nil || !d.opts.allErrors {
+		return err
+	}
+	d.errs = append(d.errs, err)
+	return nil
+}
+
+// A field is one step of a Go field path: a struct field, or an index or
+// key within the struct field it belongs to, whose tag options it shares.
+type field struct {
+	name string
+	opts tagOptions
+}
+
+func (d *decodeState) pushField(name string, opts tagOptions) {
+	d.fields = append(d.fields, field{name, opts})
+}
+
+// pushIndex pushes the slice index or map key name of the current field.
+func (d *decodeState) pushIndex(name string) {
+	d.pushField(name, d.tagOptions())
+}
+
+// tagOptions returns the tag options of the struct field being decoded.
+func (d *decodeState) tagOptions() tagOptions {
+	if len(d.fields) == 0 {
+		return ""
+	}
+	return d.fields[len(d.fields)-1].opts
+}
+
+func (d *decodeState) popField() {
+	d.fields = d.fields[:len(d.fields)-1]
+}
+
+func (d *decodeState) fieldPath() string {
+	var sb strings.Builder
+	for _, f := range d.fields {
+		if sb.Len() > 0 && !strings.HasPrefix(f.name, "[") {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(f.name)
+	}
+	return sb.String()
 }
 
 func propsFromBytes(data []byte, prefix string) (*props, error) {
-	scanner := bufio.NewScanner(bytes.NewBuffer(data))
+	return propsFromReader(bytes.NewReader(data), prefix)
+}
+
+func propsFromReader(r io.Reader, prefix string) (*props, error) {
+	lr := newLineReader(r)
 
 	var kv = map[string]string{}
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// skip empty line
-		if len(line) == 0 {
-			continue
+	for {
+		line, err := lr.readLine()
+		if err == io.EOF {
+			break
 		}
-		// skip comment line
-		if strings.HasPrefix(line, "#") {
-			continue
+		if err != nil {
+			return nil, err
 		}
 
-		parts := strings.Split(line, "=")
-		if len(parts) < 2 {
-			return nil, InvalidPropBytes
+		rk, rv := splitKeyValue(line)
+		k, err := unescape(rk)
+		if err != nil {
+			return nil, lr.errorAt(err.(*syntaxError).off, err.Error())
+		}
+		v, err := unescape(rv)
+		if err != nil {
+			return nil, lr.errorAt(len(line)-len(rv)+err.(*syntaxError).off, err.Error())
 		}
-		// NOTE: allow value to contain "="
-		k, v := strings.TrimSpace(parts[0]), strings.TrimSpace(strings.Join(parts[1:], "="))
 
 		if prefix != "" {
 			if !strings.HasPrefix(k, prefix) {
@@ -51,24 +160,158 @@ func propsFromBytes(data []byte, prefix string) (*props, error) {
 		kv[k] = v
 	}
 
-	return &props{kv}, nil
+	return &props{kv: kv}, nil
+}
+
+func (p *props) unmarshal(v interface{}, opts ...Option) error {
+	return p.unmarshalKey("", v, opts...)
 }
 
-func (p *props) unmarshal(v interface{}) error {
+// unmarshalKey decodes the value or the subtree at key, the root for the
+// empty key, into the value pointed to by v. Keys outside of it are ignored.
+func (p *props) unmarshalKey(key string, v interface{}, opts ...Option) error {
 	rv := reflect.ValueOf(v)
-	// NOTE: must be non-nil pointer to struct
-	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type().Kind() != reflect.Struct {
+	// NOTE: must be non-nil pointer
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return InvalidUnmarshalError
 	}
 
-	return p.value("", rv)
+	d := &decodeState{opts: newOptions(opts), used: map[string]bool{}}
+	p = &props{kv: p.kv, prefix: p.prefix, d: d}
+	if key != "" {
+		p = p.scope(key)
+	}
+	if d.opts.fold != nil {
+		p = p.resolveConflicts()
+	}
+	err := p.decode(key, rv.Elem())
+	if md := p.d.opts.metadata; md != nil {
+		*md = Metadata{
+			Keys:   p.d.usedKeys(p.kv),
+			Unused: p.d.unusedKeys(p.kv),
+			Unset:  p.d.unset,
+		}
+	}
+	return err
+}
+
+// scope returns a view holding only key and the keys below it, unlike
+// subprops keeping them whole.
+func (p *props) scope(key string) *props {
+	kv := map[string]string{}
+	for k, v := range p.kv {
+		if _, ok := p.cutPrefix(k+".", key+"."); ok {
+			kv[k] = v
+		} else if _, ok := p.cutPrefix(k, key+"["); ok {
+			kv[k] = v
+		}
+	}
+	return &props{kv: kv, prefix: p.prefix, d: p.d}
+}
+
+// decode stores the values at key into rv, then reports the problems that
+// are only known once the whole input has been walked.
+func (p *props) decode(key string, rv reflect.Value) error {
+	if err := p.value(key, rv); err != nil {
+		return err
+	}
+	if len(p.d.missing) > 0 {
+		err := &RequiredKeyError{Keys: p.d.missing}
+		if err := p.d.saveError(err); err != nil {
+			return err
+		}
+	}
+	if p.d.opts.disallowUnknownKeys {
+		if keys := p.d.unusedKeys(p.kv); len(keys) > 0 {
+			err := &UnknownKeyError{Keys: keys}
+			if err := p.d.saveError(err); err != nil {
+				return err
+			}
+		}
+	}
+	if len(p.d.errs) > 0 {
+		return &MultiError{Errors: p.d.errs}
+	}
+	return nil
+}
+
+// typeError describes the failure to decode raw value s at key into a value
+// of type t.
+func (p *props) typeError(key, s string, t reflect.Type, err error) error {
+	return p.d.saveError(&UnmarshalTypeError{
+		Key:   p.prefix + key,
+		Value: s,
+		Field: p.d.fieldPath(),
+		Type:  t,
+		Err:   err,
+	})
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// textUnmarshaler returns v as an encoding.TextUnmarshaler when its pointer
+// implements it.
+func textUnmarshaler(v reflect.Value) (encoding.TextUnmarshaler, bool) {
+	if v.Kind() == reflect.Ptr || !v.CanAddr() || !v.Addr().Type().Implements(textUnmarshalerType) {
+		return nil, false
+	}
+	return v.Addr().Interface().(encoding.TextUnmarshaler), true
+}
+
+// isNested reports whether values of type t are decoded from a subtree of
+// keys rather than from a single key.
+func isNested(t reflect.Type) bool {
+	t = indirect(t)
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// isScalar reports whether values of type t are decoded from a single key
+// only.
+func isScalar(t reflect.Type) bool {
+	t = indirect(t)
+	switch {
+	case reflect.PtrTo(t).Implements(unmarshalerType):
+		return false
+	case t == durationType || t == timeType || reflect.PtrTo(t).Implements(textUnmarshalerType):
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+		return false
+	}
+	return true
 }
 
 func (p *props) value(key string, v reflect.Value) (err error) {
+	if ok, err := p.hook(key, v); ok {
+		return err
+	}
+
+	if u, ok := propertiesUnmarshaler(v); ok {
+		if !p.has(key) {
+			return nil
+		}
+		return p.d.saveError(u.UnmarshalProperties(&Properties{p.subprops(key)}))
+	}
+
+	switch v.Type() {
+	case durationType:
+		return p.valueDuration(key, v)
+	case timeType:
+		return p.valueTime(key, v)
+	}
+
+	if u, ok := textUnmarshaler(v); ok {
+		return p.valueText(key, v, u)
+	}
+
 	switch v.Kind() {
 	default:
 		err = p.valueBasicType(key, v)
 	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
 		err = p.value(key, v.Elem())
 	case reflect.Struct:
 		err = p.valueStruct(key, v)
@@ -76,38 +319,126 @@ func (p *props) value(key string, v reflect.Value) (err error) {
 		err = p.valueMap(key, v)
 	case reflect.Slice:
 		err = p.valueSlice(key, v)
+	case reflect.Array:
+		err = p.valueArray(key, v)
+	case reflect.Interface:
+		err = p.valueInterface(key, v)
 	}
 
 	return err
 }
 
 func (p *props) valueStruct(key string, v reflect.Value) error {
-	for i := 0; i < v.NumField(); i++ {
-		vf, tf := v.Field(i), v.Type().Field(i)
-
-		if !vf.CanInterface() {
+	fields := p.d.opts.structFields(v.Type())
+	for _, f := range fields {
+		vf, _ := fieldByIndex(v, f.index, true)
+
+		if f.rest {
+			p.d.pushField(f.name, f.opts)
+			err := p.rest(key, fields).value("", vf)
+			p.d.popField()
+			if err != nil {
+				return err
+			}
 			continue
 		}
 
 		if vf.Kind() == reflect.Ptr {
-			vf.Set(reflect.New(tf.Type.Elem()))
+			vf.Set(reflect.New(f.typ.Elem()))
 		}
 
-		// TODO: support opts
-		kk, _ := parseTag(tf.Tag.Get(tagName))
+		kk := f.key
+		if key != "" {
+			kk = fmt.Sprintf("%s.%s", key, kk)
+		}
 
-		if kk == "-" {
-			continue
+		p.d.pushField(f.name, f.opts)
+		pp := p
+		if !p.has(kk) {
+			if def, ok := f.opts.Get("default"); ok {
+				pp = p.defaults(kk, def, f.typ, f.opts)
+			} else {
+				// NOTE: the fields of a nested struct are reported instead
+				if !isNested(f.typ) {
+					p.d.unset = append(p.d.unset, p.d.fieldPath())
+				}
+				if f.opts.Contains("required") {
+					p.d.missing = append(p.d.missing, p.prefix+kk)
+					p.d.popField()
+					continue
+				}
+			}
 		}
 
-		if key != "" {
-			kk = fmt.Sprintf("%s.%s", key, kk)
+		err := pp.value(kk, vf)
+		p.d.popField()
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if err := p.value(kk, vf); err != nil {
-			return nil
+// rest returns the view of the keys below key that none of the fields
+// takes, for the rest fields among them.
+func (p *props) rest(key string, fields []structField) *props {
+	sub := p.subprops(key)
+	kv := map[string]string{}
+	var orig map[string]string
+	if sub.orig != nil {
+		orig = map[string]string{}
+	}
+	for k, v := range sub.kv {
+		if sub.claims(fields, k) {
+			continue
+		}
+		kv[k] = v
+		if orig != nil {
+			orig[k] = sub.orig[k]
 		}
 	}
+	return &props{kv: kv, prefix: sub.prefix, orig: orig, d: p.d}
+}
+
+func (p *props) valueText(key string, v reflect.Value, u encoding.TextUnmarshaler) error {
+	s, ok := p.get(key)
+	if !ok {
+		return nil
+	}
+
+	if err := u.UnmarshalText([]byte(s)); err != nil {
+		return p.typeError(key, s, v.Type(), err)
+	}
+	return nil
+}
+
+func (p *props) valueDuration(key string, v reflect.Value) error {
+	s, ok := p.get(key)
+	if !ok {
+		return nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return p.typeError(key, s, v.Type(), err)
+	}
+	v.SetInt(int64(d))
+	return nil
+}
+
+// valueTime parses RFC 3339 times, or the layout given by the layout= tag
+// option.
+func (p *props) valueTime(key string, v reflect.Value) error {
+	s, ok := p.get(key)
+	if !ok {
+		return nil
+	}
+
+	t, err := time.Parse(timeLayout(p.d.tagOptions()), s)
+	if err != nil {
+		return p.typeError(key, s, v.Type(), err)
+	}
+	v.Set(reflect.ValueOf(t))
 	return nil
 }
 
@@ -119,6 +450,14 @@ func (p *props) valueBasicType(key string, v reflect.Value) error {
 		return nil
 	}
 
+	if err := setBasicType(v, s); err != nil {
+		return p.typeError(key, s, v.Type(), err)
+	}
+	return nil
+}
+
+// setBasicType parses s into v of a bool, number or string kind.
+func setBasicType(v reflect.Value, s string) error {
 	switch v.Kind() {
 	case reflect.Uint:
 		fallthrough
@@ -129,7 +468,7 @@ func (p *props) valueBasicType(key string, v reflect.Value) error {
 	case reflect.Uint32:
 		fallthrough
 	case reflect.Uint64:
-		uiv, err := strconv.ParseUint(s, 10, 64)
+		uiv, err := strconv.ParseUint(s, 10, v.Type().Bits())
 		if err != nil {
 			return err
 		}
@@ -143,7 +482,7 @@ func (p *props) valueBasicType(key string, v reflect.Value) error {
 	case reflect.Int32:
 		fallthrough
 	case reflect.Int64:
-		iv, err := strconv.ParseInt(s, 10, 64)
+		iv, err := strconv.ParseInt(s, 10, v.Type().Bits())
 		if err != nil {
 			return err
 		}
@@ -151,19 +490,19 @@ func (p *props) valueBasicType(key string, v reflect.Value) error {
 	case reflect.Float32:
 		fallthrough
 	case reflect.Float64:
-		fv, err := strconv.ParseFloat(s, 64)
+		fv, err := strconv.ParseFloat(s, v.Type().Bits())
 		if err != nil {
 			return err
 		}
 		v.Set(reflect.ValueOf(fv).Convert(v.Type()))
 	case reflect.String:
-		v.Set(reflect.ValueOf(s))
+		v.Set(reflect.ValueOf(s).Convert(v.Type()))
 	case reflect.Bool:
 		bv, err := strconv.ParseBool(s)
 		if err != nil {
 			return err
 		}
-		v.Set(reflect.ValueOf(bv))
+		v.Set(reflect.ValueOf(bv).Convert(v.Type()))
 	default:
 		return UnsupportedTypeError
 	}
@@ -174,86 +513,171 @@ func (p *props) valueBasicType(key string, v reflect.Value) error {
 func (p *props) valueMap(key string, v reflect.Value) (err error) {
 	m := reflect.MakeMap(v.Type())
 	pp := p.subprops(key)
-	for kk := range pp.kv {
-		mv := reflect.New(v.Type().Elem())
-
-		vv := mv
-		// allocate a new value for the pointer
-		valueIsPtr := mv.Elem().Type().Kind() == reflect.Ptr
-		if valueIsPtr {
-			vv = reflect.New(v.Type().Elem().Elem())
+	keys := pp.mapKeys()
+	if isScalar(v.Type().Elem()) {
+		// NOTE: a scalar is read from a single key, so the whole rest of
+		// every key is a map key, e.g. "a.b" for a.b=1 or "x.y.z" for
+		// "x.y".z=1
+		keys = pp.keys()
+	}
+	for _, mk := range keys {
+		// NOTE: value allocates the element when it is a pointer
+		mv := reflect.New(v.Type().Elem()).Elem()
+
+		p.d.pushIndex(fmt.Sprintf("[%s]", unquoteKey(mk)))
+		kv, err := pp.mapKey(mk, v.Type().Key())
+		if err == nil {
+			err = pp.value(mk, mv)
 		}
-
-		mk := strings.Split(kk, ".")[0]
-		err = pp.value(mk, vv)
+		p.d.popField()
 		if err != nil {
-			return
+			return err
 		}
-
-		if valueIsPtr {
-			mv.Elem().Set(vv)
+		if !kv.IsValid() {
+			continue
 		}
 
-		m.SetMapIndex(reflect.ValueOf(mk), mv.Elem())
+		m.SetMapIndex(kv, mv)
 	}
 	v.Set(m)
 	return
 }
 
-func (p *props) valueSlice(key string, v reflect.Value) (err error) {
-	var spp = map[string]*props{}
-	var sepp = map[string]*props{}
+var (
+	treeMapType   = reflect.TypeOf(map[string]interface{}{})
+	treeSliceType = reflect.TypeOf([]interface{}{})
+)
 
-	i := 0
-	for {
-		sk := fmt.Sprintf("%s[%d]", key, i)
-		if !p.hasKeyPrefix(sk) {
-			break
-		}
+// valueInterface decodes into an empty interface the tree of key: a
+// map[string]interface{} for dotted subkeys, which win over a value of key
+// itself, an []interface{} for indexed subkeys, or else the raw value, typed
+// with InferTypes.
+func (p *props) valueInterface(key string, v reflect.Value) error {
+	if v.NumMethod() > 0 {
+		return p.valueBasicType(key, v)
+	}
 
-		if pp := p.subprops(sk); !pp.isEmpty() {
-			spp[sk] = pp
+	var x reflect.Value
+	switch {
+	case key == "" || p.hasKeyPrefix(key+"."):
+		x = reflect.New(treeMapType).Elem()
+		if err := p.valueMap(key, x); err != nil {
+			return err
 		}
-
-		if epp := p.exactSubprops(sk); !epp.isEmpty() {
-			sepp[sk] = epp
+	case p.hasKeyPrefix(key + "["):
+		x = reflect.New(treeSliceType).Elem()
+		if err := p.valueSlice(key, x); err != nil {
+			return err
+		}
+	default:
+		s, ok := p.get(key)
+		if !ok {
+			return nil
+		}
+		x = reflect.ValueOf(s)
+		if p.d.opts.inferTypes {
+			x = reflect.ValueOf(inferType(s))
 		}
+	}
+	v.Set(x)
+	return nil
+}
+
+// inferType returns s as an int64, a float64 or a bool when it reads as one,
+// and as a string otherwise.
+func inferType(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
 
-		i += 1
+// valueArray decodes the elements like valueSlice does, failing when there
+// are more of them than the array holds.
+func (p *props) valueArray(key string, v reflect.Value) error {
+	// NOTE: unlike a slice, an array without any key is left as it was
+	if !p.has(key) {
+		return nil
 	}
 
-	slice := reflect.MakeSlice(v.Type(), 0, len(spp))
+	sv := reflect.New(reflect.SliceOf(v.Type().Elem())).Elem()
+	if err := p.valueSlice(key, sv); err != nil {
+		return err
+	}
 
-	for ii := 0; ii < len(spp); ii++ {
-		sk := fmt.Sprintf("%s[%d]", key, ii)
-		pp := spp[sk]
+	if sv.Len() > v.Len() {
+		err := fmt.Errorf("%d elements exceed the array length %d", sv.Len(), v.Len())
+		var s string
+		if k, ok := p.lookup(key); ok {
+			s = p.kv[k]
+		}
+		return p.typeError(key, s, v.Type(), err)
+	}
 
-		var ev reflect.Value
-		if v.Type().Elem().Kind() == reflect.Ptr {
-			ev = reflect.New(v.Type().Elem().Elem())
-			if err := pp.value("", ev); err != nil {
-				return err
-			}
-			slice = reflect.Append(slice, ev)
-		} else {
-			ev = reflect.New(v.Type().Elem())
-			if err := pp.value("", ev); err != nil {
-				return err
-			}
-			slice = reflect.Append(slice, ev.Elem())
+	reflect.Copy(v, sv)
+	for i := sv.Len(); i < v.Len(); i++ {
+		v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+	}
+	return nil
+}
+
+// mapKey converts the key mk, unquoted, into a map key of type t, which is
+// either a time.Duration, an encoding.TextUnmarshaler or of a bool, number or
+// string kind, as mapKeyString formats them. It returns the zero Value when
+// the failure was saved by AllErrors.
+func (p *props) mapKey(mk string, t reflect.Type) (reflect.Value, error) {
+	kv := reflect.New(t).Elem()
+	s := unquoteKey(mk)
+
+	var err error
+	if t == durationType {
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			kv.SetInt(int64(d))
 		}
+	} else if u, ok := textUnmarshaler(kv); ok {
+		err = u.UnmarshalText([]byte(s))
+	} else {
+		err = setBasicType(kv, s)
 	}
+	if err != nil {
+		return reflect.Value{}, p.typeError(mk, s, t, err)
+	}
+	return kv, nil
+}
 
-	for ii := 0; ii < len(sepp); ii++ {
-		sk := fmt.Sprintf("%s[%d]", key, ii)
-		epp := sepp[sk]
+func (p *props) valueSlice(key string, v reflect.Value) (err error) {
+	opts := p.d.tagOptions()
+	if sep, ok := opts.splitSep(); ok {
+		if s, ok := p.get(key); ok {
+			return p.split(key, s, sep, !opts.Contains("notrim")).valueSlice(key, v)
+		}
+	}
+
+	// NOTE: elements are read from key[0] up to the first missing index, and
+	// each of them may be a value or a subtree, e.g. key[0][1] or key[0].name
+	n := 0
+	for p.has(fmt.Sprintf("%s[%d]", key, n)) {
+		n++
+	}
 
-		ev := reflect.New(v.Type().Elem())
-		err := epp.value(sk, ev)
+	slice := reflect.MakeSlice(v.Type(), n, n)
+	for i := 0; i < n; i++ {
+		p.d.pushIndex(fmt.Sprintf("[%d]", i))
+		err = p.value(fmt.Sprintf("%s[%d]", key, i), slice.Index(i))
+		p.d.popField()
 		if err != nil {
 			return err
 		}
-		slice = reflect.Append(slice, ev.Elem())
 	}
 
 	v.Set(slice)
@@ -261,27 +685,53 @@ func (p *props) valueSlice(key string, v reflect.Value) (err error) {
 }
 
 func (p *props) subprops(prefix string) *props {
+	// NOTE: the empty prefix is the root of this view
+	if prefix == "" {
+		return p
+	}
+
 	var kv = map[string]string{}
+	var orig map[string]string
+	if p.fold() != nil {
+		orig = map[string]string{}
+	}
 
 	for k, v := range p.kv {
-		if strings.HasPrefix(k, prefix+".") {
-			kv[k[len(prefix)+1:]] = v
+		if rest, ok := p.cutPrefix(k, prefix+"."); ok {
+			kv[rest] = v
+			if orig != nil {
+				orig[rest] = p.fullKey(k)
+			}
 		}
 	}
 
-	return &props{kv}
+	return &props{kv: kv, prefix: p.prefix + prefix + ".", orig: orig, d: p.d}
 }
 
-func (p *props) exactSubprops(name string) *props {
-	var kv = map[string]string{}
-
-	for k, v := range p.kv {
-		if k == name {
-			kv[k] = v
+// mapKeys returns the distinct first segments of the keys, still quoted, in
+// sorted order.
+func (p *props) mapKeys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for k := range p.kv {
+		mk := firstSegment(k)
+		if !seen[mk] {
+			seen[mk] = true
+			keys = append(keys, mk)
 		}
 	}
+	sort.Strings(keys)
+	return keys
+}
 
-	return &props{kv}
+// keys returns the keys of the view, sorted.
+func (p *props) keys() []string {
+	keys := make([]string, 0, len(p.kv))
+	for k := range p.kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (p *props) isEmpty() bool {
@@ -289,13 +739,76 @@ func (p *props) isEmpty() bool {
 }
 
 func (p *props) get(k string) (string, bool) {
-	v, ok := p.kv[k]
-	return v, ok
+	k, ok := p.lookup(k)
+	if !ok {
+		return "", false
+	}
+	p.use(k)
+	return p.kv[k], true
+}
+
+// use marks the key k of the view as consumed.
+func (p *props) use(k string) {
+	p.d.used[p.fullKey(k)] = true
+}
+
+// fullKey returns the full key path of the key k of the view.
+func (p *props) fullKey(k string) string {
+	if full, ok := p.orig[k]; ok {
+		return full
+	}
+	return p.prefix + k
+}
+
+// indirect returns the type t points to, through any number of pointers.
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// has reports whether key holds a value or a subtree of values.
+func (p *props) has(key string) bool {
+	if key == "" {
+		return !p.isEmpty()
+	}
+	if _, ok := p.lookup(key); ok {
+		return true
+	}
+	return p.hasKeyPrefix(key+".") || p.hasKeyPrefix(key+"[")
+}
+
+// defaults returns a view holding the default value def of the field at key,
+// split on commas into elements when the field is a slice or an array. The
+// default of a field with the split option is split like the value of its
+// key would be.
+func (p *props) defaults(key, def string, t reflect.Type, opts tagOptions) *props {
+	kind := indirect(t).Kind()
+	if _, ok := opts.splitSep(); !ok && (kind == reflect.Slice || kind == reflect.Array) {
+		return p.split(key, def, ",", true)
+	}
+	return &props{kv: map[string]string{key: def}, prefix: p.prefix, d: p.d}
+}
+
+// split returns a view holding the elements of the list s as indexed keys
+// key[0], key[1]..., optionally trimmed of surrounding whitespace.
+func (p *props) split(key, s, sep string, trim bool) *props {
+	kv := map[string]string{}
+	if s != "" {
+		for i, e := range strings.Split(s, sep) {
+			if trim {
+				e = strings.TrimSpace(e)
+			}
+			kv[fmt.Sprintf("%s[%d]", key, i)] = e
+		}
+	}
+	return &props{kv: kv, prefix: p.prefix, d: p.d}
 }
 
 func (p *props) hasKeyPrefix(prefix string) bool {
 	for k := range p.kv {
-		if strings.HasPrefix(k, prefix) {
+		if _, ok := p.cutPrefix(k, prefix); ok {
 			return true
 		}
 	}
@@ -1,8 +1,14 @@
 package properties
 
 import (
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"net"
+	"net/netip"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestUnmarshalKV__int(t *testing.T) {
@@ -452,6 +458,84 @@ func TestPropsFromBytes(t *testing.T) {
 		assert.Equal(t, want, p.kv)
 	})
 
+	t.Run("java separators and comments", func(t *testing.T) {
+		input := []byte("! bang comment\n" +
+			"a:hello\n" +
+			"b world\n" +
+			"c = x \n" +
+			"d\t:\tfoo\n" +
+			"e\n" +
+			"f=a:b=c\n")
+		want := map[string]string{
+			"a": "hello",
+			"b": "world",
+			"c": "x ",
+			"d": "foo",
+			"e": "",
+			"f": "a:b=c",
+		}
+
+		p, err := propsFromBytes(input, "")
+		assert.NoError(t, err)
+		assert.Equal(t, want, p.kv)
+	})
+
+	t.Run("line continuations", func(t *testing.T) {
+		input := []byte("fruits = apple, banana, \\\n" +
+			"         pear, cantaloupe\r\n" +
+			"path=c:\\\\dir\\\\\n" +
+			"# comment \\\n" +
+			"last=1\\\n")
+		want := map[string]string{
+			"fruits": "apple, banana, pear, cantaloupe",
+			"path":   "c:\\dir\\",
+			"last":   "1",
+		}
+
+		p, err := propsFromBytes(input, "")
+		assert.NoError(t, err)
+		assert.Equal(t, want, p.kv)
+	})
+
+	t.Run("escaped separators in key", func(t *testing.T) {
+		input := []byte("a\\=b=c\n" +
+			"x\\:y\\ z:w\n")
+		want := map[string]string{
+			"a=b":   "c",
+			"x:y z": "w",
+		}
+
+		p, err := propsFromBytes(input, "")
+		assert.NoError(t, err)
+		assert.Equal(t, want, p.kv)
+	})
+
+	t.Run("escape sequences", func(t *testing.T) {
+		input := []byte(`
+			msg=line1\nline2\ttab\\
+			name=\u4e2d\u6587
+			emoji=\ud83d\ude00
+			lone=\ud83dx
+			\ lead=\ \ x
+		`)
+		want := map[string]string{
+			"msg":   "line1\nline2\ttab\\",
+			"name":  "中文",
+			"emoji": "😀",
+			"lone":  "\ufffdx",
+			" lead": "  x",
+		}
+
+		p, err := propsFromBytes(input, "")
+		assert.NoError(t, err)
+		assert.Equal(t, want, p.kv)
+	})
+
+	t.Run("malformed unicode escape", func(t *testing.T) {
+		_, err := propsFromBytes([]byte(`a=\u12g4`), "")
+		assert.True(t, errors.Is(err, InvalidPropBytes))
+	})
+
 	t.Run("with prefix", func(t *testing.T) {
 		input := []byte(`
 			a.a=hello
@@ -512,3 +596,1197 @@ func TestUnmarshalKV__unexported_field_in_struct(t *testing.T) {
 	assert.NoError(t, unmarshalKV(input, &given))
 	assert.Equal(t, want, given)
 }
+
+func TestUnmarshalKV__type_error(t *testing.T) {
+	type Server struct {
+		Host string `properties:"host"`
+		Port int    `properties:"port"`
+	}
+
+	type S struct {
+		Servers []Server         `properties:"servers"`
+		Weights map[string]uint8 `properties:"weights"`
+	}
+
+	t.Run("slice element", func(t *testing.T) {
+		var input = map[string]string{
+			"servers[0].host": "a",
+			"servers[0].port": "80",
+			"servers[1].host": "b",
+			"servers[1].port": "80",
+			"servers[2].host": "c",
+			"servers[2].port": "http",
+		}
+
+		var given S
+		err := unmarshalKV(input, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "servers[2].port", te.Key)
+		assert.Equal(t, "http", te.Value)
+		assert.Equal(t, "Servers[2].Port", te.Field)
+		assert.Equal(t, reflect.TypeOf(0), te.Type)
+		assert.Equal(t, `properties: cannot unmarshal "http" at key "servers[2].port" into Go field Servers[2].Port of type int: `+
+			`strconv.ParseInt: parsing "http": invalid syntax`, err.Error())
+	})
+
+	t.Run("map value", func(t *testing.T) {
+		var input = map[string]string{
+			"weights.a": "300",
+		}
+
+		var given S
+		err := unmarshalKV(input, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "weights.a", te.Key)
+		assert.Equal(t, "Weights[a]", te.Field)
+	})
+
+	t.Run("nested struct", func(t *testing.T) {
+		type N struct {
+			On bool `properties:"on"`
+		}
+		type S struct {
+			Nested N `properties:"nested"`
+		}
+
+		var given S
+		err := unmarshalKV(map[string]string{"nested.on": "yes"}, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "nested.on", te.Key)
+		assert.Equal(t, "Nested.On", te.Field)
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		type S struct {
+			C chan int `properties:"c"`
+		}
+
+		var given S
+		err := unmarshalKV(map[string]string{"c": "1"}, &given)
+		assert.True(t, errors.Is(err, UnsupportedTypeError))
+	})
+}
+
+func TestUnmarshalKV__all_errors(t *testing.T) {
+	type Server struct {
+		Host string `properties:"host"`
+		Port int    `properties:"port"`
+	}
+
+	type S struct {
+		Name    string            `properties:"name"`
+		Debug   bool              `properties:"debug"`
+		Servers []Server          `properties:"servers"`
+		Limits  map[string]uint16 `properties:"limits"`
+	}
+
+	var input = map[string]string{
+		"name":            "app",
+		"debug":           "maybe",
+		"servers[0].host": "a",
+		"servers[0].port": "http",
+		"servers[1].host": "b",
+		"servers[1].port": "8080",
+		"limits.a":        "1",
+		"limits.b":        "-1",
+		"limits.c":        "70000",
+	}
+
+	t.Run("stop at first error", func(t *testing.T) {
+		var given S
+		err := unmarshalKV(input, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "debug", te.Key)
+	})
+
+	t.Run("collect all errors", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(input, &given, AllErrors())
+
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		var keys []string
+		for _, e := range me.Errors {
+			keys = append(keys, e.(*UnmarshalTypeError).Key)
+		}
+		assert.Equal(t, []string{"debug", "servers[0].port", "limits.b", "limits.c"}, keys)
+
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "debug", te.Key)
+
+		assert.Equal(t, "app", given.Name)
+		assert.Equal(t, 8080, given.Servers[1].Port)
+		assert.Equal(t, uint16(1), given.Limits["a"])
+	})
+
+	t.Run("no errors", func(t *testing.T) {
+		var given S
+		assert.NoError(t, UnmarshalKV(map[string]string{"name": "app"}, &given, AllErrors()))
+	})
+}
+
+func TestUnmarshalKV__default(t *testing.T) {
+	type DB struct {
+		Host string `properties:"host,default=localhost"`
+		Port int    `properties:"port,default=5432"`
+	}
+
+	type S struct {
+		Port    int      `properties:"port,default=8080"`
+		Debug   *bool    `properties:"debug,default=true"`
+		Ratio   float64  `properties:"ratio,default=0.5"`
+		Hosts   []string `properties:"hosts,default=a.example, b.example,c.example"`
+		Empty   []int    `properties:"empty,default="`
+		Name    string   `properties:"name,default=app"`
+		DB      DB       `properties:"db"`
+		Replica []DB     `properties:"replicas"`
+	}
+
+	t.Run("absent keys", func(t *testing.T) {
+		var input = map[string]string{
+			"name":                "svc",
+			"replicas[0].host":    "r0",
+			"replicas[1].port":    "6432",
+			"replicas[1].host":    "r1",
+			"replicas[2].unknown": "x",
+		}
+
+		debug := true
+		var want = S{
+			Port:  8080,
+			Debug: &debug,
+			Ratio: 0.5,
+			Hosts: []string{"a.example", "b.example", "c.example"},
+			Empty: []int{},
+			Name:  "svc",
+			DB:    DB{Host: "localhost", Port: 5432},
+			Replica: []DB{
+				{Host: "r0", Port: 5432},
+				{Host: "r1", Port: 6432},
+				{Host: "localhost", Port: 5432},
+			},
+		}
+
+		var given S
+		assert.NoError(t, unmarshalKV(input, &given))
+		assert.Equal(t, want, given)
+	})
+
+	t.Run("present keys win", func(t *testing.T) {
+		var input = map[string]string{
+			"port":     "9090",
+			"hosts[0]": "z.example",
+			"db.port":  "1",
+		}
+
+		var given S
+		assert.NoError(t, unmarshalKV(input, &given))
+		assert.Equal(t, 9090, given.Port)
+		assert.Equal(t, []string{"z.example"}, given.Hosts)
+		assert.Equal(t, DB{Host: "localhost", Port: 1}, given.DB)
+	})
+
+	t.Run("invalid default", func(t *testing.T) {
+		type S struct {
+			Port int `properties:"port,default=http"`
+		}
+
+		var given S
+		err := unmarshalKV(map[string]string{}, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "port", te.Key)
+		assert.Equal(t, "http", te.Value)
+	})
+}
+
+func TestUnmarshalKV__required(t *testing.T) {
+	type DB struct {
+		Host     string `properties:"host,required"`
+		Password string `properties:"password,required"`
+		Port     int    `properties:"port,required,default=5432"`
+	}
+
+	type S struct {
+		Name     string        `properties:"name,required"`
+		DB       DB            `properties:"db,required"`
+		Replicas []DB          `properties:"replicas"`
+		Shards   map[string]DB `properties:"shards"`
+	}
+
+	t.Run("all present", func(t *testing.T) {
+		var input = map[string]string{
+			"name":        "app",
+			"db.host":     "localhost",
+			"db.password": "secret",
+		}
+
+		var given S
+		assert.NoError(t, unmarshalKV(input, &given))
+		assert.Equal(t, DB{Host: "localhost", Password: "secret", Port: 5432}, given.DB)
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		var input = map[string]string{
+			"db.host":              "localhost",
+			"db.pasword":           "typo",
+			"replicas[0].host":     "r0",
+			"replicas[1].host":     "r1",
+			"replicas[1].password": "secret",
+			"shards.b.password":    "secret",
+			"shards.a.host":        "a",
+		}
+
+		var given S
+		err := unmarshalKV(input, &given)
+		var re *RequiredKeyError
+		assert.True(t, errors.As(err, &re))
+		assert.Equal(t, []string{
+			"name",
+			"db.password",
+			"replicas[0].password",
+			"shards.a.password",
+			"shards.b.host",
+		}, re.Keys)
+		assert.Equal(t, "properties: missing required keys: name, db.password, "+
+			"replicas[0].password, shards.a.password, shards.b.host", err.Error())
+	})
+
+	t.Run("missing struct", func(t *testing.T) {
+		var given S
+		err := unmarshalKV(map[string]string{"name": "app"}, &given)
+		var re *RequiredKeyError
+		assert.True(t, errors.As(err, &re))
+		assert.Equal(t, []string{"db"}, re.Keys)
+	})
+
+	t.Run("with all errors", func(t *testing.T) {
+		var input = map[string]string{
+			"db.host":     "localhost",
+			"db.password": "secret",
+			"db.port":     "x",
+		}
+
+		var given S
+		err := UnmarshalKV(input, &given, AllErrors())
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		assert.Len(t, me.Errors, 2)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(me.Errors[0], &te))
+		var re *RequiredKeyError
+		assert.True(t, errors.As(me.Errors[1], &re))
+		assert.Equal(t, []string{"name"}, re.Keys)
+	})
+}
+
+func TestUnmarshalKV__disallow_unknown_keys(t *testing.T) {
+	type Server struct {
+		Host string `properties:"host"`
+	}
+
+	type S struct {
+		Name    string            `properties:"name"`
+		Bio     string            `properties:"-"`
+		Servers []Server          `properties:"servers"`
+		Labels  map[string]string `properties:"labels"`
+	}
+
+	var input = map[string]string{
+		"name":            "app",
+		"bio":             "ignored",
+		"nmae":            "typo",
+		"servers[0].host": "a",
+		"servers[0].port": "80",
+		"labels.a":        "x",
+	}
+
+	t.Run("allowed by default", func(t *testing.T) {
+		var given S
+		assert.NoError(t, unmarshalKV(input, &given))
+	})
+
+	t.Run("disallowed", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(input, &given, DisallowUnknownKeys())
+		var ue *UnknownKeyError
+		assert.True(t, errors.As(err, &ue))
+		assert.Equal(t, []string{"bio", "nmae", "servers[0].port"}, ue.Keys)
+		assert.Equal(t, "properties: unknown keys: bio, nmae, servers[0].port", err.Error())
+		assert.Equal(t, "app", given.Name)
+	})
+
+	t.Run("all consumed", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{"name": "app", "labels.b": "x"}, &given, DisallowUnknownKeys())
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"b": "x"}, given.Labels)
+	})
+}
+
+func TestUnmarshalKV__metadata(t *testing.T) {
+	type Server struct {
+		Host string `properties:"host"`
+		Port int    `properties:"port,default=80"`
+	}
+
+	type S struct {
+		Name    string            `properties:"name"`
+		Debug   bool              `properties:"debug"`
+		Main    Server            `properties:"main"`
+		Servers []*Server         `properties:"servers"`
+		Labels  map[string]string `properties:"labels"`
+		Tags    []string          `properties:"tags"`
+	}
+
+	var input = map[string]string{
+		"name":            "app",
+		"nmae":            "typo",
+		"main.host":       "m",
+		"servers[0].host": "a",
+		"servers[0].ip":   "10.0.0.1",
+		"labels.a":        "x",
+	}
+
+	var md Metadata
+	var given S
+	assert.NoError(t, UnmarshalKV(input, &given, WithMetadata(&md)))
+	assert.Equal(t, Metadata{
+		Keys:   []string{"labels.a", "main.host", "name", "servers[0].host"},
+		Unused: []string{"nmae", "servers[0].ip"},
+		Unset:  []string{"Debug", "Tags"},
+	}, md)
+
+	t.Run("on failure", func(t *testing.T) {
+		var md Metadata
+		var given S
+		err := UnmarshalKV(map[string]string{"name": "app", "debug": "x", "tags[0]": "a"}, &given, WithMetadata(&md))
+		assert.Error(t, err)
+		assert.Equal(t, []string{"debug", "name"}, md.Keys)
+		assert.Equal(t, []string{"tags[0]"}, md.Unused)
+	})
+}
+
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+)
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case levelDebug:
+		return []byte("debug"), nil
+	case levelInfo:
+		return []byte("info"), nil
+	}
+	return nil, fmt.Errorf("unknown level %d", l)
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = levelDebug
+	case "info":
+		*l = levelInfo
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+func TestUnmarshalKV__text_unmarshaler(t *testing.T) {
+	type S struct {
+		IP      net.IP           `properties:"ip"`
+		Addr    netip.Addr       `properties:"addr"`
+		AddrPtr *netip.Addr      `properties:"addr_ptr"`
+		Level   level            `properties:"level"`
+		Levels  []level          `properties:"levels"`
+		ByLevel map[level]string `properties:"by_level"`
+		Prefix  map[string]level `properties:"prefix"`
+	}
+
+	var input = map[string]string{
+		"ip":             "10.0.0.1",
+		"addr":           "::1",
+		"addr_ptr":       "192.168.0.1",
+		"level":          "info",
+		"levels[0]":      "debug",
+		"levels[1]":      "info",
+		"by_level.debug": "verbose",
+		"prefix.app":     "info",
+	}
+
+	var given S
+	assert.NoError(t, unmarshalKV(input, &given))
+	assert.Equal(t, net.ParseIP("10.0.0.1"), given.IP)
+	assert.Equal(t, netip.MustParseAddr("::1"), given.Addr)
+	assert.Equal(t, netip.MustParseAddr("192.168.0.1"), *given.AddrPtr)
+	assert.Equal(t, levelInfo, given.Level)
+	assert.Equal(t, []level{levelDebug, levelInfo}, given.Levels)
+	assert.Equal(t, map[level]string{levelDebug: "verbose"}, given.ByLevel)
+	assert.Equal(t, map[string]level{"app": levelInfo}, given.Prefix)
+
+	t.Run("invalid text", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{"level": "loud", "by_level.quiet": "x"}, &given, AllErrors())
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		assert.Len(t, me.Errors, 2)
+		assert.Equal(t, `properties: cannot unmarshal "loud" at key "level" into Go field Level of type properties.level: `+
+			`unknown level "loud"`, me.Errors[0].Error())
+		assert.Equal(t, "by_level.quiet", me.Errors[1].(*UnmarshalTypeError).Key)
+	})
+}
+
+func TestUnmarshalKV__time(t *testing.T) {
+	type S struct {
+		Timeout  time.Duration   `properties:"timeout"`
+		Retry    time.Duration   `properties:"retry,default=1m30s"`
+		Backoff  []time.Duration `properties:"backoff"`
+		Created  time.Time       `properties:"created"`
+		Birthday time.Time       `properties:"birthday,layout=2006-01-02"`
+		Expires  *time.Time      `properties:"expires,layout=RFC1123"`
+		Dates    []time.Time     `properties:"dates,layout=DateOnly"`
+	}
+
+	var input = map[string]string{
+		"timeout":    "5s",
+		"backoff[0]": "100ms",
+		"backoff[1]": "1h",
+		"created":    "2019-06-01T08:30:00.5+08:00",
+		"birthday":   "1990-12-31",
+		"expires":    "Mon, 02 Jan 2006 15:04:05 UTC",
+		"dates[0]":   "2020-02-29",
+	}
+
+	var given S
+	assert.NoError(t, unmarshalKV(input, &given))
+	assert.Equal(t, 5*time.Second, given.Timeout)
+	assert.Equal(t, 90*time.Second, given.Retry)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, time.Hour}, given.Backoff)
+	assert.True(t, time.Date(2019, 6, 1, 0, 30, 0, 5e8, time.UTC).Equal(given.Created))
+	assert.Equal(t, time.Date(1990, 12, 31, 0, 0, 0, 0, time.UTC), given.Birthday)
+	assert.True(t, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC).Equal(*given.Expires))
+	assert.Equal(t, []time.Time{time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)}, given.Dates)
+
+	t.Run("invalid", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{"timeout": "5", "birthday": "1990/12/31"}, &given, AllErrors())
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		assert.Len(t, me.Errors, 2)
+		assert.Equal(t, "timeout", me.Errors[0].(*UnmarshalTypeError).Key)
+		assert.Equal(t, durationType, me.Errors[0].(*UnmarshalTypeError).Type)
+		assert.Equal(t, "birthday", me.Errors[1].(*UnmarshalTypeError).Key)
+	})
+}
+
+func TestUnmarshalKV__split(t *testing.T) {
+	type S struct {
+		Hosts    []string        `properties:"hosts,split"`
+		Ports    []int           `properties:"ports,split"`
+		Timeouts []time.Duration `properties:"timeouts,split=;"`
+		Raw      []string        `properties:"raw,split=|,notrim"`
+		Levels   []level         `properties:"levels,split,default=debug, info"`
+		Indexed  []string        `properties:"indexed,split"`
+		Empty    []string        `properties:"empty,split"`
+		Plain    []string        `properties:"plain"`
+	}
+
+	var input = map[string]string{
+		"hosts":      "a.example, b.example,c.example",
+		"ports":      "80,443",
+		"timeouts":   "1s; 1m",
+		"raw":        " a | b ",
+		"indexed[0]": "x",
+		"indexed[1]": "y",
+		"empty":      "",
+		"plain":      "a,b",
+	}
+
+	var given S
+	assert.NoError(t, unmarshalKV(input, &given))
+	assert.Equal(t, S{
+		Hosts:    []string{"a.example", "b.example", "c.example"},
+		Ports:    []int{80, 443},
+		Timeouts: []time.Duration{time.Second, time.Minute},
+		Raw:      []string{" a ", " b "},
+		Levels:   []level{levelDebug, levelInfo},
+		Indexed:  []string{"x", "y"},
+		Empty:    []string{},
+		Plain:    []string{},
+	}, given)
+
+	t.Run("invalid element", func(t *testing.T) {
+		var given S
+		err := unmarshalKV(map[string]string{"ports": "80,http"}, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "ports[1]", te.Key)
+		assert.Equal(t, "http", te.Value)
+	})
+}
+
+func TestUnmarshalKV__array(t *testing.T) {
+	type P struct {
+		X int `properties:"x"`
+	}
+
+	type S struct {
+		Vec     [3]float64 `properties:"vec"`
+		Short   [3]int     `properties:"short"`
+		Points  [2]P       `properties:"points"`
+		Listed  [2]string  `properties:"listed,split"`
+		Default [2]int     `properties:"default,default=4,5"`
+		Absent  [2]int     `properties:"absent"`
+	}
+
+	var input = map[string]string{
+		"vec[0]":      "1.5",
+		"vec[1]":      "2.5",
+		"vec[2]":      "3.5",
+		"short[0]":    "1",
+		"points[0].x": "1",
+		"points[1].x": "2",
+		"listed":      "a, b",
+	}
+
+	given := S{Short: [3]int{9, 9, 9}, Absent: [2]int{7, 8}}
+	var md Metadata
+	assert.NoError(t, unmarshalKV(input, &given, WithMetadata(&md)))
+	assert.Equal(t, S{
+		Vec:     [3]float64{1.5, 2.5, 3.5},
+		Short:   [3]int{1, 0, 0},
+		Points:  [2]P{{1}, {2}},
+		Listed:  [2]string{"a", "b"},
+		Default: [2]int{4, 5},
+		Absent:  [2]int{7, 8},
+	}, given)
+	assert.Equal(t, []string{"Absent"}, md.Unset)
+
+	t.Run("too many elements", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{
+			"vec[0]": "1", "vec[1]": "2", "vec[2]": "3", "vec[3]": "4",
+			"listed": "a,b,c",
+		}, &given, AllErrors())
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		assert.Len(t, me.Errors, 2)
+		assert.EqualError(t, me.Errors[0], `properties: cannot unmarshal key "vec" into Go field Vec of type [3]float64: `+
+			`4 elements exceed the array length 3`)
+		assert.Equal(t, "a,b,c", me.Errors[1].(*UnmarshalTypeError).Value)
+	})
+}
+
+func TestUnmarshalKV__nested_slices_and_maps(t *testing.T) {
+	type Route struct {
+		Path    string            `properties:"path"`
+		Headers map[string]string `properties:"headers"`
+	}
+
+	type S struct {
+		Matrix [][]int             `properties:"matrix"`
+		Routes []Route             `properties:"routes"`
+		Labels []map[string]string `properties:"labels"`
+		Groups map[string][]string `properties:"groups"`
+		Grid   map[string][][]int  `properties:"grid"`
+		Ptrs   []*[]int            `properties:"ptrs"`
+		Long   []int               `properties:"long"`
+	}
+
+	var input = map[string]string{
+		"matrix[0][0]":           "1",
+		"matrix[0][1]":           "5",
+		"matrix[1][0]":           "7",
+		"routes[0].path":         "/a",
+		"routes[0].headers.X-Id": "abc",
+		"routes[1].path":         "/b",
+		"labels[0].env":          "prod",
+		"labels[1].env":          "dev",
+		"labels[1].team":         "core",
+		"groups.admins[0]":       "alice",
+		"groups.admins[1]":       "bob",
+		"groups.users[0]":        "carol",
+		"grid.a[0][0]":           "1",
+		"ptrs[0][0]":             "2",
+		"long[0]":                "0",
+		"long[1]":                "1",
+		"long[2]":                "2",
+		"long[3]":                "3",
+		"long[4]":                "4",
+		"long[5]":                "5",
+		"long[6]":                "6",
+		"long[7]":                "7",
+		"long[8]":                "8",
+		"long[9]":                "9",
+		"long[10]":               "10",
+	}
+
+	ptr := []int{2}
+	var want = S{
+		Matrix: [][]int{{1, 5}, {7}},
+		Routes: []Route{
+			{Path: "/a", Headers: map[string]string{"X-Id": "abc"}},
+			{Path: "/b", Headers: map[string]string{}},
+		},
+		Labels: []map[string]string{
+			{"env": "prod"},
+			{"env": "dev", "team": "core"},
+		},
+		Groups: map[string][]string{
+			"admins": {"alice", "bob"},
+			"users":  {"carol"},
+		},
+		Grid: map[string][][]int{"a": {{1}}},
+		Ptrs: []*[]int{&ptr},
+		Long: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+	}
+
+	var given S
+	assert.NoError(t, UnmarshalKV(input, &given, DisallowUnknownKeys()))
+	assert.Equal(t, want, given)
+
+	t.Run("error path", func(t *testing.T) {
+		var given S
+		err := unmarshalKV(map[string]string{"matrix[1][2]": "x", "matrix[1][0]": "1", "matrix[1][1]": "1", "matrix[0][0]": "1"}, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "matrix[1][2]", te.Key)
+		assert.Equal(t, "Matrix[1][2]", te.Field)
+	})
+}
+
+type region string
+
+func TestUnmarshalKV__map_key_types(t *testing.T) {
+	type Shard struct {
+		Host string `properties:"host"`
+	}
+
+	type S struct {
+		Shards    map[int]Shard     `properties:"shards"`
+		Endpoints map[region]string `properties:"endpoints"`
+		Flags     map[bool]string   `properties:"flags"`
+		Weights   map[uint8][]int   `properties:"weights"`
+	}
+
+	var input = map[string]string{
+		"shards.0.host":       "a",
+		"shards.10.host":      "b",
+		"endpoints.us-east-1": "https://us.example",
+		"flags.true":          "on",
+		"weights.3[0]":        "1",
+	}
+
+	var given S
+	assert.NoError(t, unmarshalKV(input, &given))
+	assert.Equal(t, map[int]Shard{0: {"a"}, 10: {"b"}}, given.Shards)
+	assert.Equal(t, map[region]string{"us-east-1": "https://us.example"}, given.Endpoints)
+	assert.Equal(t, map[bool]string{true: "on"}, given.Flags)
+	assert.Equal(t, map[uint8][]int{3: {1}}, given.Weights)
+
+	t.Run("invalid key", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{"shards.x.host": "a", "shards.1.host": "b"}, &given, AllErrors())
+		var me *MultiError
+		assert.True(t, errors.As(err, &me))
+		assert.Len(t, me.Errors, 1)
+		te := me.Errors[0].(*UnmarshalTypeError)
+		assert.Equal(t, "shards.x", te.Key)
+		assert.Equal(t, reflect.TypeOf(0), te.Type)
+		assert.Equal(t, map[int]Shard{1: {"b"}}, given.Shards)
+	})
+}
+
+func TestUnmarshal__quoted_map_keys(t *testing.T) {
+	type Upstream struct {
+		Weight int `properties:"weight"`
+	}
+
+	type S struct {
+		Upstream map[string]Upstream `properties:"upstream"`
+		Labels   map[string]string   `properties:"labels"`
+		Hosts    map[string][]string `properties:"hosts"`
+	}
+
+	data := []byte(`
+upstream."api.example.com".weight = 3
+upstream.plain.weight = 1
+labels."a = b" = quoted separators
+labels."say ""hi""" = hello
+hosts."eu[1]"[0] = x
+`)
+
+	var given S
+	assert.NoError(t, Unmarshal(data, &given))
+	assert.Equal(t, S{
+		Upstream: map[string]Upstream{"api.example.com": {3}, "plain": {1}},
+		Labels:   map[string]string{"a = b": "quoted separators", `say "hi"`: "hello"},
+		Hosts:    map[string][]string{"eu[1]": {"x"}},
+	}, given)
+
+	t.Run("error key", func(t *testing.T) {
+		var given map[string]Upstream
+		err := UnmarshalKV(map[string]string{`upstream."a.b".weight`: "x"}, &struct {
+			Upstream *map[string]Upstream `properties:"upstream"`
+		}{&given})
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, `upstream."a.b".weight`, te.Key)
+		assert.Equal(t, "Upstream[a.b].Weight", te.Field)
+	})
+}
+
+func TestUnmarshal__interface_tree(t *testing.T) {
+	data := []byte(`
+name = app
+port = 8080
+ratio = 0.5
+debug = true
+servers[0].host = a
+servers[1].host = b
+tags[0] = x
+upstream."api.example.com".weight = 3
+`)
+
+	t.Run("strings", func(t *testing.T) {
+		var given map[string]interface{}
+		assert.NoError(t, Unmarshal(data, &given))
+		assert.Equal(t, map[string]interface{}{
+			"name":  "app",
+			"port":  "8080",
+			"ratio": "0.5",
+			"debug": "true",
+			"servers": []interface{}{
+				map[string]interface{}{"host": "a"},
+				map[string]interface{}{"host": "b"},
+			},
+			"tags": []interface{}{"x"},
+			"upstream": map[string]interface{}{
+				"api.example.com": map[string]interface{}{"weight": "3"},
+			},
+		}, given)
+	})
+
+	t.Run("infer types", func(t *testing.T) {
+		var given interface{}
+		assert.NoError(t, Unmarshal(data, &given, InferTypes()))
+		tree := given.(map[string]interface{})
+		assert.Equal(t, "app", tree["name"])
+		assert.Equal(t, int64(8080), tree["port"])
+		assert.Equal(t, 0.5, tree["ratio"])
+		assert.Equal(t, true, tree["debug"])
+		assert.Equal(t, map[string]interface{}{"weight": int64(3)},
+			tree["upstream"].(map[string]interface{})["api.example.com"])
+	})
+
+	t.Run("field", func(t *testing.T) {
+		type S struct {
+			Name  string      `properties:"name"`
+			Extra interface{} `properties:"servers"`
+			None  interface{} `properties:"none"`
+		}
+
+		var given S
+		assert.NoError(t, Unmarshal(data, &given))
+		assert.Equal(t, "app", given.Name)
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"host": "a"},
+			map[string]interface{}{"host": "b"},
+		}, given.Extra)
+		assert.Nil(t, given.None)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		var given map[string]interface{}
+		assert.NoError(t, Unmarshal(data, &given))
+		out, err := Marshal(given)
+		assert.NoError(t, err)
+
+		var again map[string]interface{}
+		assert.NoError(t, Unmarshal(out, &again))
+		assert.Equal(t, given, again)
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		var given map[string]interface{}
+		assert.Equal(t, InvalidUnmarshalError, Unmarshal(data, given))
+	})
+}
+
+func TestUnmarshalKey__non_struct(t *testing.T) {
+	input := []byte(`
+timeout = 5s
+hosts[0] = a.example
+hosts[1] = b.example
+limits.cpu = 2
+limits.mem = 512
+name = app
+`)
+
+	t.Run("scalar", func(t *testing.T) {
+		var d time.Duration
+		assert.NoError(t, UnmarshalKey("timeout", input, &d))
+		assert.Equal(t, 5*time.Second, d)
+
+		var name string
+		assert.NoError(t, UnmarshalKey("name", input, &name))
+		assert.Equal(t, "app", name)
+	})
+
+	t.Run("slice and array", func(t *testing.T) {
+		var hosts []string
+		assert.NoError(t, UnmarshalKey("hosts", input, &hosts))
+		assert.Equal(t, []string{"a.example", "b.example"}, hosts)
+
+		var arr [2]string
+		assert.NoError(t, UnmarshalKey("hosts", input, &arr))
+		assert.Equal(t, [2]string{"a.example", "b.example"}, arr)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		var limits map[string]int
+		assert.NoError(t, UnmarshalKey("limits", input, &limits))
+		assert.Equal(t, map[string]int{"cpu": 2, "mem": 512}, limits)
+	})
+
+	t.Run("unknown keys are scoped", func(t *testing.T) {
+		var limits map[string]int
+		var md Metadata
+		assert.NoError(t, UnmarshalKey("limits", input, &limits, DisallowUnknownKeys(), WithMetadata(&md)))
+		assert.Equal(t, []string{"limits.cpu", "limits.mem"}, md.Keys)
+		assert.Empty(t, md.Unused)
+	})
+
+	t.Run("error key", func(t *testing.T) {
+		var n int
+		err := UnmarshalKey("name", input, &n)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "name", te.Key)
+	})
+
+	t.Run("top level", func(t *testing.T) {
+		var kv map[string]string
+		assert.NoError(t, Unmarshal([]byte("a=1\nb=2\n"), &kv))
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, kv)
+
+		var list []int
+		assert.NoError(t, UnmarshalKV(map[string]string{"[0]": "1", "[1]": "2"}, &list))
+		assert.Equal(t, []int{1, 2}, list)
+	})
+}
+
+type BaseServer struct {
+	Host string `properties:"host"`
+	Port int    `properties:"port"`
+}
+
+type baseTLS struct {
+	Cert string `properties:"cert"`
+}
+
+func TestUnmarshalKV__embedded(t *testing.T) {
+	type API struct {
+		BaseServer
+		*baseTLS
+		Path string `properties:"path"`
+	}
+
+	type Cache struct {
+		Base BaseServer `properties:"base,inline"`
+		Size int        `properties:"size"`
+	}
+
+	type S struct {
+		API   API   `properties:"api"`
+		Cache Cache `properties:"cache"`
+	}
+
+	type unexported struct {
+		baseTLS
+		Name string `properties:"name"`
+	}
+
+	input := map[string]string{
+		"api.host":   "a.example",
+		"api.port":   "8080",
+		"api.path":   "/v1",
+		"cache.host": "c.example",
+		"cache.port": "6379",
+		"cache.size": "10",
+		"cert":       "tls.pem",
+		"name":       "x",
+	}
+
+	var given S
+	assert.NoError(t, UnmarshalKV(input, &given))
+	assert.Equal(t, API{BaseServer: BaseServer{"a.example", 8080}, Path: "/v1"}, given.API)
+	assert.Equal(t, Cache{Base: BaseServer{"c.example", 6379}, Size: 10}, given.Cache)
+
+	var u unexported
+	assert.NoError(t, UnmarshalKV(input, &u))
+	assert.Equal(t, unexported{baseTLS{"tls.pem"}, "x"}, u)
+
+	t.Run("error field", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{"api.port": "x"}, &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "api.port", te.Key)
+		assert.Equal(t, "API.BaseServer.Port", te.Field)
+	})
+}
+
+func TestUnmarshalKV__naming(t *testing.T) {
+	type DB struct {
+		Host     string
+		MaxConns int `properties:",default=10"`
+	}
+
+	type S struct {
+		DB      DB
+		Timeout time.Duration `properties:"timeout_ms"`
+	}
+
+	t.Run("as is", func(t *testing.T) {
+		var given S
+		assert.NoError(t, UnmarshalKV(map[string]string{"DB.Host": "a", "DB.MaxConns": "5"}, &given))
+		assert.Equal(t, S{DB: DB{"a", 5}}, given)
+	})
+
+	t.Run("kebab case", func(t *testing.T) {
+		var given S
+		input := map[string]string{"db.host": "a", "timeout_ms": "1s"}
+		assert.NoError(t, UnmarshalKV(input, &given, WithNaming(KebabCase)))
+		assert.Equal(t, S{DB: DB{"a", 10}, Timeout: time.Second}, given)
+	})
+}
+
+func TestUnmarshalKV__relaxed_binding(t *testing.T) {
+	type Pool struct {
+		MaxPoolSize int    `properties:"max-pool-size"`
+		MinIdle     int    `properties:"min-idle"`
+		Name        string `properties:"name"`
+	}
+
+	type S struct {
+		Pool    Pool              `properties:"pool"`
+		Servers []Pool            `properties:"servers"`
+		Labels  map[string]string `properties:"labels"`
+	}
+
+	input := map[string]string{
+		"POOL.MAX_POOL_SIZE":       "10",
+		"pool.minIdle":             "2",
+		"Pool.Name":                "main",
+		"servers[0].max_pool_size": "3",
+		"SERVERS[1].name":          "b",
+		"labels.Team":              "core",
+	}
+
+	t.Run("exact by default", func(t *testing.T) {
+		var given S
+		assert.NoError(t, UnmarshalKV(input, &given))
+		assert.Equal(t, Pool{}, given.Pool)
+	})
+
+	t.Run("relaxed", func(t *testing.T) {
+		var given S
+		var md Metadata
+		assert.NoError(t, UnmarshalKV(input, &given, RelaxedBinding(), DisallowUnknownKeys(), WithMetadata(&md)))
+		assert.Equal(t, S{
+			Pool:    Pool{10, 2, "main"},
+			Servers: []Pool{{MaxPoolSize: 3}, {Name: "b"}},
+			Labels:  map[string]string{"Team": "core"},
+		}, given)
+		assert.Contains(t, md.Keys, "POOL.MAX_POOL_SIZE")
+		assert.Contains(t, md.Keys, "SERVERS[1].name")
+		assert.Empty(t, md.Unused)
+	})
+
+	t.Run("unmarshal key", func(t *testing.T) {
+		var given Pool
+		data := []byte("POOL.MAX_POOL_SIZE=10\nother.name=x\n")
+		assert.NoError(t, UnmarshalKey("pool", data, &given, RelaxedBinding(), DisallowUnknownKeys()))
+		assert.Equal(t, Pool{MaxPoolSize: 10}, given)
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		input := map[string]string{
+			"pool.max-pool-size": "1",
+			"pool.maxPoolSize":   "2",
+			"POOL.MAX_POOL_SIZE": "3",
+			"pool.minIdle":       "4",
+			"pool.MinIdle":       "5",
+		}
+
+		var conflicts [][]string
+		onConflict := OnKeyConflict(func(used string, ignored []string) {
+			conflicts = append(conflicts, append([]string{used}, ignored...))
+		})
+
+		for i := 0; i < 5; i++ {
+			conflicts = nil
+			var given S
+			assert.NoError(t, UnmarshalKV(input, &given, RelaxedBinding(), onConflict))
+			assert.Equal(t, Pool{MaxPoolSize: 1, MinIdle: 5}, given.Pool)
+			assert.Equal(t, [][]string{
+				{"pool.MinIdle", "pool.minIdle"},
+				{"pool.max-pool-size", "POOL.MAX_POOL_SIZE", "pool.maxPoolSize"},
+			}, conflicts)
+		}
+	})
+}
+
+func TestUnmarshalKV__case_insensitive_keys(t *testing.T) {
+	type DB struct {
+		Host     string `properties:"host"`
+		MaxConns int    `properties:"max-conns"`
+	}
+
+	type S struct {
+		DB      DB             `properties:"db"`
+		Hosts   []string       `properties:"hosts"`
+		Weights map[string]int `properties:"weights"`
+	}
+
+	input := map[string]string{
+		"DB.Host":       "a",
+		"db.MAX-CONNS":  "5",
+		"Hosts[0]":      "x",
+		"HOSTS[1]":      "y",
+		"Weights.Alpha": "1",
+	}
+
+	var given S
+	assert.NoError(t, UnmarshalKV(input, &given, CaseInsensitiveKeys(), DisallowUnknownKeys()))
+	assert.Equal(t, S{
+		DB:      DB{"a", 5},
+		Hosts:   []string{"x", "y"},
+		Weights: map[string]int{"Alpha": 1},
+	}, given)
+
+	t.Run("separators still matter", func(t *testing.T) {
+		var given S
+		err := UnmarshalKV(map[string]string{"db.max_conns": "5"}, &given, CaseInsensitiveKeys(), DisallowUnknownKeys())
+		var ue *UnknownKeyError
+		assert.True(t, errors.As(err, &ue))
+		assert.Equal(t, []string{"db.max_conns"}, ue.Keys)
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		var used string
+		var ignored []string
+		onConflict := OnKeyConflict(func(u string, i []string) {
+			used, ignored = u, i
+		})
+
+		var given S
+		input := map[string]string{"DB.HOST": "b", "db.host": "a", "Db.Host": "c"}
+		assert.NoError(t, UnmarshalKV(input, &given, CaseInsensitiveKeys(), onConflict))
+		assert.Equal(t, "a", given.DB.Host)
+		assert.Equal(t, "db.host", used)
+		assert.Equal(t, []string{"DB.HOST", "Db.Host"}, ignored)
+	})
+
+	t.Run("conflicts with underscores", func(t *testing.T) {
+		type Env struct {
+			DBHost string `properties:"db_host"`
+		}
+
+		var used string
+		var ignored []string
+		onConflict := OnKeyConflict(func(u string, i []string) {
+			used, ignored = u, i
+		})
+
+		var given Env
+		input := map[string]string{"DB_HOST": "upper", "db_host": "lower"}
+		assert.NoError(t, UnmarshalKV(input, &given, CaseInsensitiveKeys(), onConflict))
+		assert.Equal(t, "lower", given.DBHost)
+		assert.Equal(t, "db_host", used)
+		assert.Equal(t, []string{"DB_HOST"}, ignored)
+	})
+
+	t.Run("relaxed binding wins", func(t *testing.T) {
+		var given S
+		input := map[string]string{"db.max_conns": "5"}
+		assert.NoError(t, UnmarshalKV(input, &given, RelaxedBinding(), CaseInsensitiveKeys()))
+		assert.Equal(t, 5, given.DB.MaxConns)
+	})
+}
+
+func TestUnmarshal__scalar_map_subtrees(t *testing.T) {
+	t.Run("root map", func(t *testing.T) {
+		var given map[string]string
+		assert.NoError(t, Unmarshal([]byte("a.b=1\nc=2\na=3\nlist[0]=x\n"), &given, DisallowUnknownKeys()))
+		assert.Equal(t, map[string]string{"a.b": "1", "c": "2", "a": "3", "list[0]": "x"}, given)
+	})
+
+	t.Run("quoted subtree", func(t *testing.T) {
+		var given struct {
+			Upstream map[string]int `properties:"upstream"`
+		}
+		data := []byte("upstream.\"api.example.com\".weight=3\nupstream.\"x.y\"=2\nupstream.a.\"b\"[0]=1\n")
+		assert.NoError(t, Unmarshal(data, &given))
+		assert.Equal(t, map[string]int{"api.example.com.weight": 3, "x.y": 2, "a.b[0]": 1}, given.Upstream)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		var given map[int]string
+		err := Unmarshal([]byte("1=a\n1.5=b\n"), &given)
+		var te *UnmarshalTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "1.5", te.Key)
+	})
+}
+
+func TestUnmarshalKV__embedded_shadowing(t *testing.T) {
+	type pBase struct {
+		Host string `properties:"host"`
+		Port int    `properties:"port"`
+	}
+
+	type Other struct {
+		Port int `properties:"port"`
+		Zone string
+	}
+
+	type Tagged struct {
+		Zone string `properties:"Zone"`
+	}
+
+	type Outer struct {
+		pBase
+		Other
+		Tagged
+		Host  string            `properties:"host"`
+		Extra map[string]string `properties:",inline"`
+	}
+
+	input := map[string]string{
+		"host": "outer",
+		"port": "1",
+		"Zone": "z",
+		"name": "a",
+	}
+
+	var given Outer
+	var md Metadata
+	assert.NoError(t, UnmarshalKV(input, &given, WithMetadata(&md)))
+	assert.Equal(t, Outer{
+		Tagged: Tagged{"z"},
+		Host:   "outer",
+		Extra:  map[string]string{"port": "1", "name": "a"},
+	}, given)
+	assert.Equal(t, []string{"Zone", "host", "name", "port"}, md.Keys)
+}